@@ -0,0 +1,184 @@
+// Package accesslog logs a JSON line per HTTP request or gRPC call with
+// sampling, redaction and body truncation.
+package accesslog
+
+import (
+	"encoding/json"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/94peter/log"
+)
+
+const (
+	redacted = "[REDACTED]"
+
+	defaultSlowThreshold = 3 * time.Second
+	defaultMaxBodyBytes  = 4096
+)
+
+var (
+	defaultRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	defaultRedactFields  = []string{"password", "token", "secret"}
+)
+
+// Config of the access log. Failed and slow requests are always logged,
+// the others with the SampleRate probability, 1 when zero. Requests slower
+// than SlowThreshold, 3s by default, are logged as warnings. Bodies are only
+// logged with LogBody and are truncated to MaxBodyBytes, 4KB by default.
+type Config struct {
+	SampleRate    float64
+	SlowThreshold time.Duration
+	LogHeaders    bool
+	LogBody       bool
+	MaxBodyBytes  int
+	// RedactHeaders and RedactFields default to Authorization, Cookie,
+	// Set-Cookie, X-Api-Key and password, token, secret. Fields are matched
+	// case insensitively at any depth of JSON bodies.
+	RedactHeaders []string
+	RedactFields  []string
+}
+
+type entry struct {
+	Type      string            `json:"type"`
+	Method    string            `json:"method"`
+	Route     string            `json:"route,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Status    int               `json:"status,omitempty"`
+	Code      string            `json:"code,omitempty"`
+	Duration  float64           `json:"duration_ms"`
+	Slow      bool              `json:"slow,omitempty"`
+	ClientIP  string            `json:"client_ip,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	TraceID   string            `json:"trace_id,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Request   string            `json:"request,omitempty"`
+	Response  string            `json:"response,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+type logger struct {
+	log.Logger
+	sampleRate    float64
+	slowThreshold time.Duration
+	logHeaders    bool
+	logBody       bool
+	maxBodyBytes  int
+	headers       map[string]bool
+	fields        map[string]bool
+	fieldPattern  *regexp.Regexp
+}
+
+func newLogger(l log.Logger, c *Config) *logger {
+	if c == nil {
+		c = &Config{}
+	}
+	lg := &logger{
+		Logger:        l,
+		sampleRate:    c.SampleRate,
+		slowThreshold: c.SlowThreshold,
+		logHeaders:    c.LogHeaders,
+		logBody:       c.LogBody,
+		maxBodyBytes:  c.MaxBodyBytes,
+		headers:       make(map[string]bool),
+		fields:        make(map[string]bool),
+	}
+	if lg.sampleRate <= 0 {
+		lg.sampleRate = 1
+	}
+	if lg.maxBodyBytes <= 0 {
+		lg.maxBodyBytes = defaultMaxBodyBytes
+	}
+	if lg.slowThreshold <= 0 {
+		lg.slowThreshold = defaultSlowThreshold
+	}
+	headers, fields := c.RedactHeaders, c.RedactFields
+	if len(headers) == 0 {
+		headers = defaultRedactHeaders
+	}
+	if len(fields) == 0 {
+		fields = defaultRedactFields
+	}
+	for _, h := range headers {
+		lg.headers[strings.ToLower(h)] = true
+	}
+	quoted := make([]string, 0, len(fields))
+	for _, f := range fields {
+		lg.fields[strings.ToLower(f)] = true
+		quoted = append(quoted, regexp.QuoteMeta(f))
+	}
+	// redacts string values of bodies that can not be parsed, e.g. truncated
+	lg.fieldPattern = regexp.MustCompile(`(?i)("(?:` + strings.Join(quoted, "|") + `)"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+	return lg
+}
+
+// sampled tells if a request without failure should be logged.
+func (l *logger) sampled() bool {
+	return l.sampleRate >= 1 || rand.Float64() < l.sampleRate
+}
+
+func (l *logger) header(name string, values []string) string {
+	if l.headers[strings.ToLower(name)] {
+		return redacted
+	}
+	return strings.Join(values, ",")
+}
+
+// body redacts and truncates b. truncated tells b was cut before reading.
+func (l *logger) body(b []byte, truncated bool) string {
+	if len(b) == 0 {
+		return ""
+	}
+	var v any
+	if !truncated && json.Unmarshal(b, &v) == nil {
+		if out, err := json.Marshal(l.redact(v)); err == nil {
+			b = out
+		}
+	} else {
+		b = l.fieldPattern.ReplaceAll(b, []byte(`$1"`+redacted+`"`))
+	}
+	if len(b) > l.maxBodyBytes {
+		b = b[:l.maxBodyBytes]
+		truncated = true
+	}
+	if truncated {
+		return string(b) + "...(truncated)"
+	}
+	return string(b)
+}
+
+func (l *logger) redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, fv := range v {
+			if l.fields[strings.ToLower(k)] {
+				v[k] = redacted
+				continue
+			}
+			v[k] = l.redact(fv)
+		}
+	case []any:
+		for i := range v {
+			v[i] = l.redact(v[i])
+		}
+	}
+	return v
+}
+
+func (l *logger) write(e *entry, failed bool) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		l.Logger.Errorf("access log marshal fail: %v", err)
+		return
+	}
+	switch {
+	case failed:
+		l.Logger.Error(string(b))
+	case e.Slow:
+		l.Logger.Warn(string(b))
+	default:
+		l.Logger.Info(string(b))
+	}
+}
@@ -0,0 +1,107 @@
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/94peter/log"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type recordLog struct {
+	log.Logger
+	level string
+	msgs  []string
+}
+
+func (l *recordLog) Info(msg string)  { l.level = "info"; l.msgs = append(l.msgs, msg) }
+func (l *recordLog) Warn(msg string)  { l.level = "warn"; l.msgs = append(l.msgs, msg) }
+func (l *recordLog) Error(msg string) { l.level = "error"; l.msgs = append(l.msgs, msg) }
+
+func TestGinHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := &recordLog{}
+	r := gin.New()
+	r.Use(GinHandler(l, &Config{LogHeaders: true, LogBody: true, MaxBodyBytes: 64, SlowThreshold: time.Hour}))
+	var received string
+	r.POST("/users/:id", func(c *gin.Context) {
+		b, _ := io.ReadAll(c.Request.Body)
+		received = string(b)
+		c.JSON(http.StatusOK, gin.H{"token": "t0k3n", "name": "joe"})
+	})
+
+	body := `{"name":"joe","Password":"s3cret","profile":{"secret":"x"}}`
+	req := httptest.NewRequest(http.MethodPost, "/users/1", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("Accept", "application/json")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if received != body {
+		t.Errorf("handler got body %q", received)
+	}
+	if len(l.msgs) != 1 || l.level != "info" {
+		t.Fatalf("expected one info log, got %s %q", l.level, l.msgs)
+	}
+	var e entry
+	if err := json.Unmarshal([]byte(l.msgs[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Route != "/users/:id" || e.Status != http.StatusOK || e.Headers["Authorization"] != redacted || e.Headers["Accept"] != "application/json" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	for _, leak := range []string{"s3cret", `"x"`, "t0k3n", "abc"} {
+		if strings.Contains(l.msgs[0], leak) {
+			t.Errorf("%s not redacted: %s", leak, l.msgs[0])
+		}
+	}
+
+	// truncated bodies are redacted as text
+	l.msgs = nil
+	long := `{"password":"` + strings.Repeat("p", 100) + `"}`
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users/1", strings.NewReader(long)))
+	if err := json.Unmarshal([]byte(l.msgs[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(e.Request, "ppp") || !strings.HasSuffix(e.Request, "...(truncated)") {
+		t.Errorf("unexpected truncated body %q", e.Request)
+	}
+}
+
+func TestInterceptorSamplingAndFailures(t *testing.T) {
+	l := &recordLog{}
+	unary := Interceptor(l, &Config{SampleRate: 0.000001, LogBody: true}).UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+	}
+	fail := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Internal, "boom")
+	}
+	for i := 0; i < 10; i++ {
+		unary(context.Background(), &healthpb.HealthCheckRequest{}, info, ok)
+	}
+	if len(l.msgs) != 0 {
+		t.Errorf("expected successful calls to be sampled out, got %q", l.msgs)
+	}
+	unary(context.Background(), &healthpb.HealthCheckRequest{Service: "db"}, info, fail)
+	if len(l.msgs) != 1 || l.level != "error" {
+		t.Fatalf("expected the failure to be logged as error, got %s %q", l.level, l.msgs)
+	}
+	var e entry
+	if err := json.Unmarshal([]byte(l.msgs[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Code != "Internal" || e.Error != "boom" || e.Request != `{"service":"db"}` {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
@@ -0,0 +1,115 @@
+package accesslog
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/94peter/log"
+	"github.com/94peter/microservice/requestid"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GinHandler logs the requests with l as configured by c. Only the logged
+// part of the request body is buffered.
+func GinHandler(l log.Logger, c *Config) gin.HandlerFunc {
+	lg := newLogger(l, c)
+	return func(c *gin.Context) {
+		start := time.Now()
+		var reqBody []byte
+		var reqTruncated bool
+		var respBody *limitedBuffer
+		if lg.logBody {
+			reqBody, reqTruncated = peekBody(c.Request, lg.maxBodyBytes)
+			respBody = &limitedBuffer{limit: lg.maxBodyBytes}
+			c.Writer = &bodyWriter{ResponseWriter: c.Writer, body: respBody}
+		}
+		c.Next()
+
+		duration := time.Since(start)
+		status := c.Writer.Status()
+		failed := status >= http.StatusInternalServerError
+		slow := duration >= lg.slowThreshold
+		if !failed && !slow && !lg.sampled() {
+			return
+		}
+		e := &entry{
+			Type:      "http",
+			Method:    c.Request.Method,
+			Route:     c.FullPath(),
+			Path:      c.Request.URL.Path,
+			Status:    status,
+			Duration:  float64(duration.Microseconds()) / 1000,
+			Slow:      slow,
+			ClientIP:  c.ClientIP(),
+			RequestID: requestid.FromContext(c),
+			Error:     c.Errors.String(),
+		}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.HasTraceID() {
+			e.TraceID = sc.TraceID().String()
+		}
+		if lg.logHeaders {
+			e.Headers = make(map[string]string, len(c.Request.Header))
+			for name, values := range c.Request.Header {
+				e.Headers[name] = lg.header(name, values)
+			}
+		}
+		if lg.logBody {
+			e.Request = lg.body(reqBody, reqTruncated)
+			e.Response = lg.body(respBody.Bytes(), respBody.truncated)
+		}
+		lg.write(e, failed)
+	}
+}
+
+// peekBody reads up to limit bytes of the request body and puts them back
+// in front of the unread rest.
+func peekBody(req *http.Request, limit int) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, false
+	}
+	b, _ := io.ReadAll(io.LimitReader(req.Body, int64(limit)+1))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+	if len(b) > limit {
+		return b[:limit], true
+	}
+	return b, false
+}
+
+// limitedBuffer keeps the first limit bytes written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+type bodyWriter struct {
+	gin.ResponseWriter
+	body *limitedBuffer
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyWriter) WriteString(s string) (int, error) {
+	w.body.Write([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,98 @@
+package accesslog
+
+import (
+	"context"
+	"time"
+
+	"github.com/94peter/log"
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/requestid"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Interceptor logs the gRPC calls with l as configured by c. Calls failing
+// with Unknown, Internal, Unavailable or DataLoss count as failed. Only the
+// messages of unary calls are logged.
+func Interceptor(l log.Logger, c *Config) interceptor.Interceptor {
+	lg := newLogger(l, c)
+	return interceptor.NewSimpleInterceptor(
+		func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			start := time.Now()
+			err := handler(srv, ss)
+			lg.logCall(ss.Context(), info.FullMethod, start, nil, nil, err)
+			return err
+		},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			lg.logCall(ctx, info.FullMethod, start, req, resp, err)
+			return resp, err
+		},
+	)
+}
+
+func (l *logger) logCall(ctx context.Context, method string, start time.Time, req, resp interface{}, err error) {
+	duration := time.Since(start)
+	code := status.Code(err)
+	failed := serverFailure(code)
+	slow := duration >= l.slowThreshold
+	if !failed && !slow && !l.sampled() {
+		return
+	}
+	e := &entry{
+		Type:      "grpc",
+		Method:    method,
+		Code:      code.String(),
+		Duration:  float64(duration.Microseconds()) / 1000,
+		Slow:      slow,
+		RequestID: requestid.FromContext(ctx),
+	}
+	if err != nil {
+		e.Error = status.Convert(err).Message()
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		e.ClientIP = p.Addr.String()
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		e.TraceID = sc.TraceID().String()
+	}
+	if l.logHeaders {
+		md, _ := metadata.FromIncomingContext(ctx)
+		e.Headers = make(map[string]string, len(md))
+		for name, values := range md {
+			e.Headers[name] = l.header(name, values)
+		}
+	}
+	if l.logBody {
+		e.Request = l.message(req)
+		e.Response = l.message(resp)
+	}
+	l.write(e, failed)
+}
+
+func (l *logger) message(m interface{}) string {
+	msg, ok := m.(proto.Message)
+	if !ok || msg == nil {
+		return ""
+	}
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		return ""
+	}
+	return l.body(b, false)
+}
+
+func serverFailure(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
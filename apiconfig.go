@@ -0,0 +1,138 @@
+package microservice
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/94peter/microservice/accesslog"
+	"github.com/94peter/microservice/health"
+	"github.com/94peter/microservice/tlstool"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+)
+
+// apiServerConfig is read from the api section of the viper config:
+//
+//	api:
+//	  host: 0.0.0.0
+//	  port: 8080
+//	  read_timeout: 30s
+//	  read_header_timeout: 10s
+//	  write_timeout: 30s
+//	  idle_timeout: 120s
+//	  max_header_bytes: 1048576
+//	  tls:
+//	    cert: /etc/tls/tls.crt
+//	    key: /etc/tls/tls.key
+type apiServerConfig struct {
+	Host              string
+	Port              uint
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	TLSCert           string
+	TLSKey            string
+}
+
+// getAccessLogConfigFromViper reads the api.accesslog section:
+//
+//	api:
+//	  accesslog:
+//	    sample_rate: 0.1
+//	    slow_threshold: 1s
+//	    log_headers: true
+//	    log_body: true
+//	    max_body_bytes: 2048
+//	    redact_headers: [Authorization, Cookie]
+//	    redact_fields: [password, creditCard]
+func getAccessLogConfigFromViper() *accesslog.Config {
+	return &accesslog.Config{
+		SampleRate:    viper.GetFloat64("api.accesslog.sample_rate"),
+		SlowThreshold: viper.GetDuration("api.accesslog.slow_threshold"),
+		LogHeaders:    viper.GetBool("api.accesslog.log_headers"),
+		LogBody:       viper.GetBool("api.accesslog.log_body"),
+		MaxBodyBytes:  viper.GetInt("api.accesslog.max_body_bytes"),
+		RedactHeaders: viper.GetStringSlice("api.accesslog.redact_headers"),
+		RedactFields:  viper.GetStringSlice("api.accesslog.redact_fields"),
+	}
+}
+
+// getDrainDelay returns the readiness drain delay of health.drain_delay, or
+// of HEALTH_DRAIN_DELAY when the config does not set it.
+func getDrainDelay() time.Duration {
+	if viper.IsSet("health.drain_delay") {
+		return viper.GetDuration("health.drain_delay")
+	}
+	return health.DrainDelayFromEnv()
+}
+
+func getApiServerConfigFromViper() (*apiServerConfig, error) {
+	c := &apiServerConfig{
+		Host:              viper.GetString("api.host"),
+		Port:              viper.GetUint("api.port"),
+		ReadTimeout:       viper.GetDuration("api.read_timeout"),
+		ReadHeaderTimeout: viper.GetDuration("api.read_header_timeout"),
+		WriteTimeout:      viper.GetDuration("api.write_timeout"),
+		IdleTimeout:       viper.GetDuration("api.idle_timeout"),
+		MaxHeaderBytes:    viper.GetInt("api.max_header_bytes"),
+		TLSCert:           viper.GetString("api.tls.cert"),
+		TLSKey:            viper.GetString("api.tls.key"),
+	}
+	if c.Port == 0 {
+		return nil, errors.New("api.port is empty")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return nil, errors.New("api.tls.cert and api.tls.key must be set together")
+	}
+	if c.ReadHeaderTimeout == 0 {
+		c.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = defaultIdleTimeout
+	}
+	return c, nil
+}
+
+func (c *apiServerConfig) isTLS() bool {
+	return c.TLSCert != ""
+}
+
+func (c *apiServerConfig) addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(int(c.Port)))
+}
+
+// newServer returns the http.Server and, when TLS is enabled, the reloader
+// of its certificate.
+func (c *apiServerConfig) newServer(handler http.Handler) (*http.Server, *tlstool.CertReloader, error) {
+	serv := &http.Server{
+		Addr:              c.addr(),
+		Handler:           handler,
+		ReadTimeout:       c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+	if !c.isTLS() {
+		return serv, nil, nil
+	}
+	reloader, err := tlstool.NewCertReloader(c.TLSCert, c.TLSKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	serv.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	return serv, reloader, nil
+}
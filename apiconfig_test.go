@@ -0,0 +1,210 @@
+package microservice
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// writeKeyPair writes a self-signed certificate of name and its key to
+// api.crt and api.key in dir.
+func writeKeyPair(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "api.crt"), filepath.Join(dir, "api.key")
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDer},
+	} {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestGetApiServerConfigFromViper(t *testing.T) {
+	tests := []struct {
+		name string
+		conf map[string]any
+		want apiServerConfig
+		addr string
+		err  string
+	}{
+		{
+			name: "defaults",
+			conf: map[string]any{"api.port": 8080},
+			want: apiServerConfig{Port: 8080, ReadHeaderTimeout: defaultReadHeaderTimeout, IdleTimeout: defaultIdleTimeout},
+			addr: ":8080",
+		},
+		{
+			name: "timeouts",
+			conf: map[string]any{
+				"api.host":                "127.0.0.1",
+				"api.port":                9090,
+				"api.read_timeout":        "30s",
+				"api.read_header_timeout": "5s",
+				"api.write_timeout":       "1m",
+				"api.idle_timeout":        "90s",
+				"api.max_header_bytes":    4096,
+			},
+			want: apiServerConfig{
+				Host:              "127.0.0.1",
+				Port:              9090,
+				ReadTimeout:       30 * time.Second,
+				ReadHeaderTimeout: 5 * time.Second,
+				WriteTimeout:      time.Minute,
+				IdleTimeout:       90 * time.Second,
+				MaxHeaderBytes:    4096,
+			},
+			addr: "127.0.0.1:9090",
+		},
+		{
+			name: "ipv6 host",
+			conf: map[string]any{"api.host": "::1", "api.port": 8080},
+			want: apiServerConfig{Host: "::1", Port: 8080, ReadHeaderTimeout: defaultReadHeaderTimeout, IdleTimeout: defaultIdleTimeout},
+			addr: "[::1]:8080",
+		},
+		{
+			name: "tls",
+			conf: map[string]any{"api.port": 8443, "api.tls.cert": "tls.crt", "api.tls.key": "tls.key"},
+			want: apiServerConfig{Port: 8443, ReadHeaderTimeout: defaultReadHeaderTimeout, IdleTimeout: defaultIdleTimeout, TLSCert: "tls.crt", TLSKey: "tls.key"},
+			addr: ":8443",
+		},
+		{
+			name: "no port",
+			conf: map[string]any{"api.host": "127.0.0.1"},
+			err:  "api.port is empty",
+		},
+		{
+			name: "cert without key",
+			conf: map[string]any{"api.port": 8443, "api.tls.cert": "tls.crt"},
+			err:  "must be set together",
+		},
+		{
+			name: "key without cert",
+			conf: map[string]any{"api.port": 8443, "api.tls.key": "tls.key"},
+			err:  "must be set together",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			for k, v := range tt.conf {
+				viper.Set(k, v)
+			}
+			c, err := getApiServerConfigFromViper()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *c != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, *c)
+			}
+			if c.isTLS() != (tt.want.TLSCert != "") {
+				t.Errorf("unexpected isTLS %v", c.isTLS())
+			}
+			if addr := c.addr(); addr != tt.addr {
+				t.Errorf("expected addr %s, got %s", tt.addr, addr)
+			}
+		})
+	}
+}
+
+func TestApiServerConfigNewServer(t *testing.T) {
+	c := &apiServerConfig{
+		Host:              "127.0.0.1",
+		Port:              8080,
+		ReadTimeout:       time.Second,
+		ReadHeaderTimeout: 2 * time.Second,
+		WriteTimeout:      3 * time.Second,
+		IdleTimeout:       4 * time.Second,
+		MaxHeaderBytes:    4096,
+	}
+	serv, reloader, err := c.newServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloader != nil || serv.TLSConfig != nil {
+		t.Error("expected a server without TLS")
+	}
+	if serv.Addr != "127.0.0.1:8080" || serv.ReadTimeout != time.Second || serv.ReadHeaderTimeout != 2*time.Second ||
+		serv.WriteTimeout != 3*time.Second || serv.IdleTimeout != 4*time.Second || serv.MaxHeaderBytes != 4096 {
+		t.Errorf("unexpected server %+v", serv)
+	}
+
+	// a missing key pair fails
+	dir := t.TempDir()
+	c.TLSCert, c.TLSKey = filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key")
+	if _, _, err := c.newServer(nil); err == nil {
+		t.Error("expected error for a missing key pair")
+	}
+
+	c.TLSCert, c.TLSKey = writeKeyPair(t, dir, "v1")
+	serv, reloader, err = c.newServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloader == nil || serv.TLSConfig == nil || serv.TLSConfig.GetCertificate == nil {
+		t.Fatal("expected a TLS server with a certificate reloader")
+	}
+	commonName := func() string {
+		cert, err := serv.TLSConfig.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if name := commonName(); name != "v1" {
+		t.Errorf("expected certificate v1, got %s", name)
+	}
+
+	// a rotated key pair is served once reloaded
+	writeKeyPair(t, dir, "v2")
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := commonName(); name != "v2" {
+		t.Errorf("expected certificate v2, got %s", name)
+	}
+}
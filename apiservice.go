@@ -3,15 +3,25 @@ package microservice
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	stdlog "log"
 	"net/http"
-	"strconv"
-	"sync"
 	"time"
 
+	toolkitErr "github.com/94peter/api-toolkit/errors"
+	"github.com/94peter/log"
+	"github.com/94peter/microservice/accesslog"
 	"github.com/94peter/microservice/apitool"
 	"github.com/94peter/microservice/apitool/err"
 	"github.com/94peter/microservice/apitool/mid"
+	"github.com/94peter/microservice/cfg"
+	"github.com/94peter/microservice/di"
+	"github.com/94peter/microservice/grpc_tool"
+	"github.com/94peter/microservice/grpc_tool/gateway"
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/health"
+	"github.com/94peter/microservice/requestid"
+	"github.com/94peter/microservice/tlstool"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -25,27 +35,68 @@ type ginServ struct {
 	mids       []mid.GinMiddle
 	apis       []apitool.GinAPI
 	debug      bool
+	logger     log.Logger
+	accessLog  *accesslog.Config
+
+	servDI di.DI
+	cfgMgr cfg.ModelCfgMgr
+
+	gatewayCfg *grpc_tool.GrpcConfig
+	gateway    *gateway.Gateway
+
+	promReg        *prometheus.Registry
+	promCollectors []prometheus.Collector
+	promhttp       bool
+	grpcMetrics    interceptor.Interceptor
+	panics         *prometheus.CounterVec
+
+	// shutdownHooks run before the server shuts down
+	shutdownHooks []func(ctx context.Context) error
 }
 
 func (g *ginServ) defaultErrorHandler(c *gin.Context, service string, myerr error) {
-	apiErr, ok := myerr.(err.ApiError)
-	resp := gin.H{"service": service, "error": myerr.Error()}
-	if ok {
-		c.JSON(apiErr.GetStatus(), resp)
-	} else {
-		c.JSON(http.StatusInternalServerError, resp)
+	apiErr := err.AsError(myerr)
+	resp := gin.H{"service": service, "error": myerr.Error(), "request_id": requestid.FromContext(c)}
+	if apiErr.Code != "" {
+		resp["code"] = apiErr.Code
+	}
+	if len(apiErr.Details) > 0 {
+		resp["details"] = apiErr.Details
 	}
+	if len(apiErr.FieldErrors) > 0 {
+		resp["errors"] = apiErr.FieldErrors
+	}
+	c.JSON(apiErr.GetStatus(), resp)
 }
 
 func (g *ginServ) errorHandler(c *gin.Context, err error) {
 	g.errHandler(c, g.service, err)
 }
 
-func (g *ginServ) init() {
+func (g *ginServ) init() error {
+	if g.cfgMgr != nil {
+		g.cfgMgr.SetApiErrorHandler(toolkitErr.GinApiErrorHandler(g.errorHandler))
+	}
 	for _, m := range g.mids {
 		m.SetErrorHandler(g.errorHandler)
 	}
-	router := g.Use(g.getMiddles()...)
+	if err := g.initMetrics(); err != nil {
+		return err
+	}
+	metricsHandler, err := mid.MetricsHandler(g.service, g.promReg)
+	if err != nil {
+		return err
+	}
+	g.Use(requestid.GinHandler(), mid.TracingHandler(), metricsHandler)
+	g.Use(g.getBaseMiddles()...)
+	g.Use(mid.RecoveryHandler(g.errorHandler, g.onGinPanic))
+	if g.gatewayCfg != nil {
+		// transcoded calls get the DI and model config from the gRPC interceptors
+		if err := g.initGateway(); err != nil {
+			return err
+		}
+	}
+	router := g.Group("", g.getMiddles()...)
 
 	for _, a := range g.apis {
 		a.SetErrorHandler(g.errorHandler)
@@ -53,13 +104,132 @@ func (g *ginServ) init() {
 			router.Handle(h.Method, h.Path, h.Handler)
 		}
 	}
+	return nil
 }
 
-func (g *ginServ) getMiddles() []gin.HandlerFunc {
+func (g *ginServ) initMetrics() error {
+	if g.promReg == nil {
+		g.promReg = prometheus.NewRegistry()
+	}
+	for _, c := range g.promCollectors {
+		if err := g.promReg.Register(c); err != nil {
+			return err
+		}
+	}
+	g.panics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "panics_total",
+		Help:        "Total number of panics recovered from handlers.",
+		ConstLabels: prometheus.Labels{"service": g.service},
+	}, []string{"transport"})
+	if err := g.promReg.Register(g.panics); err != nil {
+		return err
+	}
+	var err error
+	g.grpcMetrics, err = interceptor.NewMetricsInterceptor(g.service, g.promReg)
+	if err != nil {
+		return err
+	}
+	if g.promhttp {
+		handler := promhttp.HandlerFor(g.promReg, promhttp.HandlerOpts{})
+		g.GET("/metrics", gin.WrapH(handler))
+	}
+	return nil
+}
+
+func (g *ginServ) onGinPanic(c *gin.Context, recovered any, stack []byte) {
+	g.panics.WithLabelValues("http").Inc()
+	g.logPanic(fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path), recovered, stack)
+}
+
+func (g *ginServ) onGrpcPanic(ctx context.Context, method string, recovered any, stack []byte) {
+	g.panics.WithLabelValues("grpc").Inc()
+}
+
+func (g *ginServ) logPanic(target string, recovered any, stack []byte) {
+	if g.logger != nil {
+		g.logger.Errorf("[%s] panic: %v\n%s", target, recovered, stack)
+		return
+	}
+	stdlog.Printf("[%s] panic: %v\n%s", target, recovered, stack)
+}
+
+// newGrpcServer serves the services of grpcCfg with the interceptors of the
+// api; panics are logged by grpcCfg and counted in the api metrics. observe
+// adds the metrics and access log of the calls, which the gin middlewares
+// already record for the calls of the gateway.
+func (g *ginServ) newGrpcServer(grpcCfg *grpc_tool.GrpcConfig, observe bool) (*grpc_tool.Server, error) {
+	interceptors := g.grpcInterceptors()
+	if observe {
+		interceptors = append(g.grpcObservers(), interceptors...)
+	}
+	return grpc_tool.NewServer(grpcCfg,
+		grpc_tool.WithServerInterceptors(interceptors...),
+		grpc_tool.WithPanicHandler(g.onGrpcPanic),
+	)
+}
+
+func (g *ginServ) initGateway() error {
+	grpcServ, err := g.newGrpcServer(g.gatewayCfg, false)
+	if err != nil {
+		return err
+	}
+	g.gateway, err = gateway.New(grpcServ)
+	if err != nil {
+		return err
+	}
+	return g.gateway.Register(g.Engine, g.errorHandler)
+}
+
+func (g *ginServ) close() {
+	if g.gateway != nil {
+		g.gateway.Close()
+	}
+}
+
+// grpcObservers record the metrics and access log of the gRPC calls served
+// alongside the api.
+func (g *ginServ) grpcObservers() []interceptor.Interceptor {
+	interceptors := []interceptor.Interceptor{g.grpcMetrics}
+	if g.logger != nil {
+		interceptors = append(interceptors, accesslog.Interceptor(g.logger, g.accessLog))
+	}
+	return interceptors
+}
+
+// grpcInterceptors inject the DI and model config of WithServiceDI into the
+// gRPC calls served alongside the api.
+func (g *ginServ) grpcInterceptors() []interceptor.Interceptor {
+	var interceptors []interceptor.Interceptor
+	if g.servDI != nil {
+		interceptors = append(interceptors, interceptor.NewSimpleInterceptor(
+			di.GrpcStreamInterceptor(g.servDI),
+			di.GrpcUnaryInterceptor(g.servDI),
+		))
+	}
+	if g.cfgMgr != nil {
+		interceptors = append(interceptors, g.cfgMgr)
+	}
+	return interceptors
+}
+
+func (g *ginServ) getBaseMiddles() []gin.HandlerFunc {
 	var middles []gin.HandlerFunc
-	if g.debug {
+	if g.logger != nil {
+		middles = append(middles, accesslog.GinHandler(g.logger, g.accessLog))
+	} else if g.debug {
 		middles = append(middles, mid.DebugHandler())
 	}
+	return middles
+}
+
+func (g *ginServ) getMiddles() []gin.HandlerFunc {
+	var middles []gin.HandlerFunc
+	if g.servDI != nil {
+		middles = append(middles, di.GinMiddleHandler(g.servDI))
+	}
+	if g.cfgMgr != nil {
+		middles = append(middles, g.cfgMgr.Handler())
+	}
 	for _, m := range g.mids {
 		middles = append(middles, m.Handler())
 	}
@@ -87,21 +257,91 @@ func WithAPI(apis ...apitool.GinAPI) options {
 	}
 }
 
+// WithServiceDI injects servDI and the model config of cfgMgr into every
+// request before the other middlewares. With NewMixServiceWithViper the same
+// injection is applied to gRPC calls. With a di.Reloader, e.g. the one of
+// MicroService.GetDIReloader, each request gets the DI loaded last.
+func WithServiceDI(servDI di.DI, cfgMgr cfg.ModelCfgMgr) options {
+	return func(g *ginServ) {
+		g.servDI = servDI
+		g.cfgMgr = cfgMgr
+	}
+}
+
+// WithGrpcGateway transcodes JSON/HTTP requests to the unary methods of the
+// services registered on grpcCfg. Methods are served on their google.api.http
+// bindings and on POST /package.Service/Method.
+func WithGrpcGateway(grpcCfg *grpc_tool.GrpcConfig) options {
+	return func(g *ginServ) {
+		g.gatewayCfg = grpcCfg
+	}
+}
+
+// WithLogger logs every request with l as configured by the api.accesslog
+// section, replacing the request dump of api.debug.
+func WithLogger(l log.Logger) options {
+	return func(g *ginServ) {
+		g.logger = l
+	}
+}
+
+// WithHealth mounts the liveness and readiness endpoints of reg. Readiness
+// flips when the service shuts down, the drain delay of health.drain_delay
+// or HEALTH_DRAIN_DELAY before the listener closes.
+func WithHealth(reg *health.Registry) options {
+	return func(g *ginServ) {
+		reg.DrainDelay = getDrainDelay()
+		g.shutdownHooks = append(g.shutdownHooks, reg.Shutdown)
+		g.GET(health.LivenessPath, reg.LivenessHandler())
+		g.GET(health.ReadinessPath, reg.ReadinessHandler())
+	}
+}
+
+// WithPromhttp serves the metrics of the service registry on /metrics, with
+// the collectors c registered on it.
 func WithPromhttp(c ...prometheus.Collector) options {
 	return func(g *ginServ) {
-		prometheus.MustRegister(c...)
-		g.GET("/metrics", func(c *gin.Context) { promhttp.Handler().ServeHTTP(c.Writer, c.Request) }).Use()
+		g.promCollectors = append(g.promCollectors, c...)
+		g.promhttp = true
 	}
 }
 
+// WithPromRegistry records the service metrics on reg instead of a registry
+// of its own, e.g. to share it with a standalone gRPC server.
+func WithPromRegistry(reg *prometheus.Registry) options {
+	return func(g *ginServ) {
+		g.promReg = reg
+	}
+}
+
+// NewApiWithViper serves the api configured by the global viper, e.g. read
+// from a remote source with configsource.ReadViper.
 func NewApiWithViper(opts ...options) (ServiceHandler, error) {
+	serv, servCfg, err := newGinServWithViper(opts...)
+	if err != nil {
+		return nil, err
+	}
+	httpServ, reloader, err := servCfg.newServer(serv.Engine)
+	if err != nil {
+		serv.close()
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		defer serv.close()
+		stdlog.Println("start api service addr:", httpServ.Addr)
+		return serv.runApiService(ctx, httpServ, reloader, nil, 0)
+	}, nil
+}
+
+func newGinServWithViper(opts ...options) (*ginServ, *apiServerConfig, error) {
 	service := viper.GetString("service")
 	if service == "" {
-		return nil, errors.New("service is empty")
+		return nil, nil, errors.New("service is empty")
 	}
-	port := viper.GetUint("api.port")
-	if port == 0 {
-		return nil, errors.New("api.port is empty")
+	servCfg, err := getApiServerConfigFromViper()
+	if err != nil {
+		return nil, nil, err
 	}
 	var mode string
 	debug := viper.GetBool("api.debug")
@@ -112,10 +352,14 @@ func NewApiWithViper(opts ...options) (ServiceHandler, error) {
 	}
 
 	gin.SetMode(mode)
+	engine := gin.New()
+	// lets handlers pass the gin context on and keep the request span
+	engine.ContextWithFallback = true
 	serv := &ginServ{
-		Engine:  gin.New(),
-		service: service,
-		debug:   debug,
+		Engine:    engine,
+		service:   service,
+		debug:     debug,
+		accessLog: getAccessLogConfigFromViper(),
 	}
 	for _, opt := range opts {
 		opt(serv)
@@ -125,38 +369,64 @@ func NewApiWithViper(opts ...options) (ServiceHandler, error) {
 		serv.errHandler = serv.defaultErrorHandler
 	}
 
-	serv.init()
-
-	return func(ctx context.Context) {
-		log.Println("start api service port:", port)
-		runApiService(ctx, &http.Server{
-			Addr:    ":" + strconv.Itoa(int(port)),
-			Handler: serv.Engine,
-		})
-	}, nil
+	if err := serv.init(); err != nil {
+		serv.close()
+		return nil, nil, err
+	}
+	return serv, servCfg, nil
 }
 
-func runApiService(ctx context.Context, serv *http.Server) {
-	var apiWait sync.WaitGroup
-	const fiveSecods = 5 * time.Second
-	apiWait.Add(1)
-	go func(srv *http.Server) {
+const apiShutdownTimeout = 5 * time.Second
 
-		for {
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				time.Sleep(fiveSecods)
-			} else if err == http.ErrServerClosed {
-				apiWait.Done()
-				return
-			}
+// runApiService serves until ctx is done and runs the shutdown hooks of g,
+// e.g. flipping readiness, before shutting serv down. drain, if set, is called while
+// serv shuts down, e.g. to stop the gRPC server it feeds, and gets
+// drainTimeout on top of the shutdown timeout of the api.
+func (g *ginServ) runApiService(ctx context.Context, serv *http.Server, reloader *tlstool.CertReloader, drain func(), drainTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func(srv *http.Server) {
+		if reloader == nil {
+			errCh <- srv.ListenAndServe()
+			return
 		}
+		errCh <- srv.ListenAndServeTLS("", "")
 	}(serv)
+	if reloader != nil {
+		go func() {
+			err := reloader.Watch(ctx, func(err error) {
+				stdlog.Println("reload api certificate fail:", err)
+			})
+			if err != nil {
+				stdlog.Println("watch api certificate fail:", err)
+			}
+		}()
+	}
 
-	<-ctx.Done()
-	ctx, cancel := context.WithTimeout(context.Background(), fiveSecods)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("api service fail: %w", err)
+	case <-ctx.Done():
+	}
+	for _, h := range g.shutdownHooks {
+		if err := h(context.Background()); err != nil {
+			stdlog.Println("shutdown hook fail:", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout+drainTimeout)
 	defer cancel()
-	if err := serv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	// the streams of serv only end once drain stops the server behind them
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- serv.Shutdown(ctx)
+	}()
+	if drain != nil {
+		drain()
+	}
+	if err := <-shutdownErr; err != nil {
+		return fmt.Errorf("server forced to shutdown: %w", err)
+	}
+	if err := <-errCh; err != http.ErrServerClosed {
+		return err
 	}
-	apiWait.Wait()
+	return nil
 }
@@ -0,0 +1,161 @@
+package microservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/94peter/microservice/apitool"
+	"github.com/94peter/microservice/apitool/err"
+	"github.com/94peter/microservice/grpc_tool"
+	"github.com/94peter/microservice/health"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+)
+
+type pingAPI struct {
+	err.CommonErrorHandler
+}
+
+func (*pingAPI) GetHandlers() []*apitool.GinHandler {
+	return []*apitool.GinHandler{{
+		Method:  http.MethodGet,
+		Path:    "/ping/:id",
+		Handler: func(c *gin.Context) { c.String(http.StatusOK, "pong") },
+	}, {
+		Method:  http.MethodGet,
+		Path:    "/panic",
+		Handler: func(c *gin.Context) { panic("boom") },
+	}}
+}
+
+func TestPromhttpPerService(t *testing.T) {
+	viper.Set("service", "test")
+	viper.Set("api.port", 8080)
+	defer viper.Reset()
+
+	// a second server must not collide with the metrics of the first one
+	for i := 0; i < 2; i++ {
+		serv, _, err := newGinServWithViper(WithAPI(&pingAPI{}), WithPromhttp())
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		serv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping/1", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d", w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		serv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		body := w.Body.String()
+		if !strings.Contains(body, `http_requests_total{method="GET",route="/ping/:id",service="test",status="200"} 1`) {
+			t.Errorf("request count not exported:\n%s", body)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	viper.Set("service", "test")
+	viper.Set("api.port", 8080)
+	defer viper.Reset()
+
+	serv, _, err := newGinServWithViper(WithAPI(&pingAPI{}), WithPromhttp())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		serv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `"service":"test"`) {
+			t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	serv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `panics_total{service="test",transport="http"} 2`) {
+		t.Errorf("panics not counted:\n%s", w.Body.String())
+	}
+}
+
+func TestApiShutdownFlipsReadiness(t *testing.T) {
+	port := freePort(t)
+	viper.Set("service", "test")
+	viper.Set("api.host", "127.0.0.1")
+	viper.Set("api.port", port)
+	viper.Set("health.drain_delay", "500ms")
+	defer viper.Reset()
+
+	reg := health.NewRegistry()
+	handler, err := NewApiWithViper(WithHealth(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.DrainDelay != 500*time.Millisecond {
+		t.Fatalf("expected the drain delay of viper, got %s", reg.DrainDelay)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- handler(ctx)
+	}()
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", port, health.ReadinessPath)
+	ready := func() int {
+		resp, err := http.Get(url)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i := 0; i < 50 && ready() != http.StatusOK; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	// still serving, but no longer ready
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready while draining, got %d", code)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGatewayCountedOnce(t *testing.T) {
+	viper.Set("service", "test")
+	viper.Set("api.port", 8080)
+	defer viper.Reset()
+
+	reg := health.NewRegistry()
+	reg.DrainDelay = 0
+	grpcCfg := &grpc_tool.GrpcConfig{Logger: &testLog{}}
+	grpcCfg.SetHealth(reg)
+	grpcCfg.SetRegisterServiceFunc(func(*grpc.Server) {})
+	serv, _, err := newGinServWithViper(WithGrpcGateway(grpcCfg), WithPromhttp())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer serv.close()
+	w := httptest.NewRecorder()
+	serv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/grpc.health.v1.Health/Check", strings.NewReader(`{}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	serv.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `http_requests_total{method="POST",route="/grpc.health.v1.Health/Check",service="test",status="200"} 1`) {
+		t.Errorf("transcoded call not counted:\n%s", body)
+	}
+	if strings.Contains(body, `grpc_server_handled_total{`) {
+		t.Errorf("transcoded call counted twice:\n%s", body)
+	}
+}
@@ -0,0 +1,118 @@
+package err
+
+import (
+	"errors"
+	"net/http"
+)
+
+// FieldError is the validation failure of a request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an ApiError with a machine readable code, a title, details and
+// field errors. It converts to a gRPC status with the same information, so
+// it can be returned from gin handlers and gRPC methods alike.
+//
+// Errors match with errors.Is by Code, so a sentinel keeps matching after
+// WithCause or WithDetail.
+type Error struct {
+	Status      int
+	Code        string
+	Title       string
+	Message     string
+	Details     map[string]string
+	FieldErrors []FieldError
+
+	cause error
+}
+
+func NewError(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) GetStatus() int {
+	return e.Status
+}
+
+// GetTitle returns the title, the status text when empty.
+func (e *Error) GetTitle() string {
+	if e.Title != "" {
+		return e.Title
+	}
+	return http.StatusText(e.Status)
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+func (e *Error) clone() *Error {
+	c := *e
+	if e.Details != nil {
+		c.Details = make(map[string]string, len(e.Details))
+		for k, v := range e.Details {
+			c.Details[k] = v
+		}
+	}
+	c.FieldErrors = append([]FieldError(nil), e.FieldErrors...)
+	return &c
+}
+
+// WithCause returns a copy of e wrapping cause.
+func (e *Error) WithCause(cause error) *Error {
+	c := e.clone()
+	c.cause = cause
+	return c
+}
+
+func (e *Error) WithTitle(title string) *Error {
+	c := e.clone()
+	c.Title = title
+	return c
+}
+
+func (e *Error) WithDetail(key, value string) *Error {
+	c := e.clone()
+	if c.Details == nil {
+		c.Details = make(map[string]string)
+	}
+	c.Details[key] = value
+	return c
+}
+
+func (e *Error) WithFieldError(field, message string) *Error {
+	c := e.clone()
+	c.FieldErrors = append(c.FieldErrors, FieldError{Field: field, Message: message})
+	return c
+}
+
+// AsError returns err as *Error, nil when err is nil. An ApiError keeps its
+// status and any other error becomes a 500.
+func AsError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	var apiErr ApiError
+	if errors.As(err, &apiErr) {
+		return &Error{Status: apiErr.GetStatus(), Message: apiErr.Error()}
+	}
+	return &Error{Status: http.StatusInternalServerError, Message: err.Error()}
+}
@@ -0,0 +1,83 @@
+package err
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errNotFound = NewError(http.StatusNotFound, "USER_NOT_FOUND", "user not found")
+
+func TestGrpcRoundTrip(t *testing.T) {
+	sent := errNotFound.WithDetail("id", "42").WithFieldError("id", "unknown id")
+	grpcErr := ToGrpcError(fmt.Errorf("lookup: %w", sent))
+	if status.Code(grpcErr) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", grpcErr)
+	}
+
+	got := FromGrpcError(grpcErr)
+	if got.Status != http.StatusNotFound || got.Code != "USER_NOT_FOUND" || got.Details["id"] != "42" ||
+		len(got.FieldErrors) != 1 || got.FieldErrors[0].Field != "id" {
+		t.Errorf("unexpected error %+v", got)
+	}
+	if !errors.Is(got, errNotFound) {
+		t.Error("expected the converted error to match the sentinel")
+	}
+	if errNotFound.Details != nil {
+		t.Error("sentinel modified by WithDetail")
+	}
+}
+
+func TestToGrpcError(t *testing.T) {
+	for err, code := range map[error]codes.Code{
+		context.Canceled: codes.Canceled,
+		fmt.Errorf("x: %w", context.DeadlineExceeded): codes.DeadlineExceeded,
+		New(http.StatusUnauthorized, "who are you"):   codes.Unauthenticated,
+		errors.New("raw"):                   codes.Internal,
+		status.Error(codes.Aborted, "kept"): codes.Aborted,
+	} {
+		if got := status.Code(ToGrpcError(err)); got != code {
+			t.Errorf("%v: expected %s, got %s", err, code, got)
+		}
+	}
+
+	// the cause of an internal error is kept for the logs only
+	cause := errors.New("dial tcp 10.0.0.1:5432: connection refused")
+	grpcErr := ToGrpcError(cause)
+	if msg := status.Convert(grpcErr).Message(); msg != internalMessage {
+		t.Errorf("expected message %q, got %q", internalMessage, msg)
+	}
+	if !errors.Is(grpcErr, cause) {
+		t.Error("expected the internal error to wrap its cause")
+	}
+	if ToGrpcError(nil) != nil || AsError(nil) != nil {
+		t.Error("expected nil for a nil error")
+	}
+}
+
+func TestProblemErrorHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	ProblemErrorHandler(c, "users", errNotFound.WithFieldError("id", "unknown id"))
+
+	if w.Code != http.StatusNotFound || w.Header().Get("Content-Type") != ProblemContentType {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Title != "Not Found" || p.Code != "USER_NOT_FOUND" || p.Instance != "/users/42" || p.Service != "users" || len(p.Errors) != 1 {
+		t.Errorf("unexpected problem %+v", p)
+	}
+}
@@ -0,0 +1,197 @@
+package err
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// HTTPStatusFromCode maps a gRPC status code to its HTTP status, following
+// google/rpc/code.proto.
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CodeFromHTTPStatus maps an HTTP status to the closest gRPC status code.
+func CodeFromHTTPStatus(status int) codes.Code {
+	switch status {
+	case 499:
+		return codes.Canceled
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	switch {
+	case status < 400:
+		return codes.OK
+	case status < 500:
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
+	}
+}
+
+// GRPCStatus returns the status of e, with its code and details as
+// ErrorInfo and its field errors as BadRequest. status.FromError and
+// status.Code use it.
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(CodeFromHTTPStatus(e.Status), e.Error())
+	var details []protoadapt.MessageV1
+	if e.Code != "" || len(e.Details) > 0 {
+		details = append(details, &errdetails.ErrorInfo{Reason: e.Code, Metadata: e.Details})
+	}
+	if len(e.FieldErrors) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, f := range e.FieldErrors {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       f.Field,
+				Description: f.Message,
+			})
+		}
+		details = append(details, br)
+	}
+	if len(details) == 0 {
+		return st
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+}
+
+// FromGrpcError turns the status of a downstream call into an Error, nil
+// when err is nil. Errors without status are converted by AsError.
+func FromGrpcError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return AsError(err)
+	}
+	e := &Error{Status: HTTPStatusFromCode(st.Code()), Message: st.Message()}
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			e.Code = d.Reason
+			e.Details = d.Metadata
+		case *errdetails.BadRequest:
+			for _, f := range d.FieldViolations {
+				e.FieldErrors = append(e.FieldErrors, FieldError{Field: f.Field, Message: f.Description})
+			}
+		}
+	}
+	return e
+}
+
+// internalMessage is sent to clients in place of the text of an error
+// that is not an ApiError.
+const internalMessage = "internal error"
+
+// internalError is the Internal status of an error that is not an ApiError.
+// Clients only get internalMessage while Error and Unwrap keep the cause for
+// the logs.
+type internalError struct {
+	cause error
+}
+
+func (e *internalError) Error() string {
+	return internalMessage + ": " + e.cause.Error()
+}
+
+func (e *internalError) Unwrap() error {
+	return e.cause
+}
+
+func (e *internalError) GRPCStatus() *status.Status {
+	return status.New(codes.Internal, internalMessage)
+}
+
+// ToGrpcError returns err as a gRPC status error. Context errors become
+// Canceled and DeadlineExceeded, ApiErrors get the code of their status and
+// other errors without status become Internal with a generic message.
+func ToGrpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	var e *Error
+	var apiErr ApiError
+	if errors.As(err, &e) || errors.As(err, &apiErr) {
+		return AsError(err).GRPCStatus().Err()
+	}
+	return &internalError{cause: err}
+}
+
+// GrpcErrorInterceptor converts the errors returned by the next interceptors
+// and handlers with ToGrpcError.
+func GrpcErrorInterceptor() interceptor.Interceptor {
+	return interceptor.NewSimpleInterceptor(
+		func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return ToGrpcError(handler(srv, ss))
+		},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			resp, err := handler(ctx, req)
+			return resp, ToGrpcError(err)
+		},
+	)
+}
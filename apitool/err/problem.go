@@ -0,0 +1,47 @@
+package err
+
+import (
+	"github.com/94peter/microservice/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is the RFC 7807 problem details of an error, extended with the
+// code, details and field errors of Error.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      string            `json:"code,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	Errors    []FieldError      `json:"errors,omitempty"`
+	Service   string            `json:"service,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+func NewProblem(err error) *Problem {
+	e := AsError(err)
+	return &Problem{
+		Type:    "about:blank",
+		Title:   e.GetTitle(),
+		Status:  e.Status,
+		Detail:  e.Error(),
+		Code:    e.Code,
+		Details: e.Details,
+		Errors:  e.FieldErrors,
+	}
+}
+
+// ProblemErrorHandler responds errors as application/problem+json. It is a
+// GinServiceErrorHandler to be set with WithErrorHandler.
+func ProblemErrorHandler(c *gin.Context, service string, err error) {
+	p := NewProblem(err)
+	p.Instance = c.Request.URL.Path
+	p.Service = service
+	p.RequestID = requestid.FromContext(c)
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(p.Status, p)
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// Deprecated: DebugHandler prints whole unredacted requests to stdout. Use
+// accesslog.GinHandler, e.g. through the WithLogger option.
 func DebugHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 
@@ -0,0 +1,56 @@
+package mid
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const unmatchedRoute = "unmatched"
+
+// MetricsHandler records the count, latency and in-flight number of the
+// requests of service on reg, labelled by route template, method and status.
+// Requests matching no route share the "unmatched" route.
+func MetricsHandler(service string, reg prometheus.Registerer) (gin.HandlerFunc, error) {
+	constLabels := prometheus.Labels{"service": service}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "http_requests_total",
+		Help:        "Total number of HTTP requests.",
+		ConstLabels: constLabels,
+	}, []string{"route", "method", "status"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        "http_request_duration_seconds",
+		Help:        "Latency of HTTP requests.",
+		ConstLabels: constLabels,
+		Buckets:     prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	inFlight := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "http_requests_in_flight",
+		Help:        "Number of HTTP requests being served.",
+		ConstLabels: constLabels,
+	}, []string{"route", "method"})
+	for _, c := range []prometheus.Collector{requests, duration, inFlight} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := c.Request.Method
+		gauge := inFlight.WithLabelValues(route, method)
+		gauge.Inc()
+		start := time.Now()
+		defer func() {
+			gauge.Dec()
+			status := strconv.Itoa(c.Writer.Status())
+			requests.WithLabelValues(route, method, status).Inc()
+			duration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+		}()
+		c.Next()
+	}, nil
+}
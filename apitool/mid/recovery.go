@@ -0,0 +1,37 @@
+package mid
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/94peter/microservice/apitool/err"
+	"github.com/gin-gonic/gin"
+)
+
+// PanicHandler is called with the recovered value and stack of a panic.
+type PanicHandler func(c *gin.Context, recovered any, stack []byte)
+
+// RecoveryHandler recovers the panics of the next handlers, reports them to
+// onPanic and responds 500 through errHandler.
+func RecoveryHandler(errHandler err.GinErrorHandler, onPanic PanicHandler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			if onPanic != nil {
+				onPanic(c, r, debug.Stack())
+			}
+			c.Abort()
+			if !c.Writer.Written() {
+				errHandler(c, err.PkgError(http.StatusInternalServerError, fmt.Errorf("internal server error")))
+			}
+		}()
+		c.Next()
+	}
+}
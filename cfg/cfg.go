@@ -6,6 +6,7 @@ import (
 	"github.com/94peter/api-toolkit/mid"
 	"github.com/94peter/microservice/di"
 	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/health"
 	"github.com/gin-gonic/gin"
 )
 
@@ -22,7 +23,22 @@ type ModelCfgMgr interface {
 
 type ctxType string
 
-const cfgKey = "model_config"
+const (
+	cfgKey = "model_config"
+
+	healthCheckUUID = "health-check"
+)
+
+// HealthChecker checks that a copy of cfg can be initialized from di.
+func HealthChecker[T ModelCfg](cfg T, servDi di.DI) health.Checker {
+	return func(ctx context.Context) error {
+		data := cfg.Copy()
+		if err := data.Init(healthCheckUUID, di.Current(servDi)); err != nil {
+			return err
+		}
+		return data.Close()
+	}
+}
 
 func GetFromGinCtx[T ModelCfg](ctx *gin.Context) (T, bool) {
 	var result T
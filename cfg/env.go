@@ -1,8 +1,10 @@
 package cfg
 
 import (
+	"encoding"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
 	"strconv"
@@ -10,66 +12,251 @@ import (
 	"time"
 )
 
-const _OPTIONAL = "opt"
+const (
+	_OPTIONAL    = "opt"
+	_DEFAULT     = "default="
+	_FILE_SUFFIX = "_FILE"
 
+	defaultSeparator       = ","
+	defaultKeyValSeparator = ":"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	urlType             = reflect.TypeOf(url.URL{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// GetFromEnv sets the fields of obj, a pointer to a struct, from the env
+// vars named by their env tags:
+//
+//	Port  int               `env:"PORT,opt,default=8080"`
+//	Hosts []string          `env:"HOSTS" envSeparator:";"`
+//	Tags  map[string]string `env:"TAGS" envKeyValSeparator:"="`
+//	DB    DBConfig          `envPrefix:"DB_"`
+//
+// A blank var fails unless it is opt or has a default; default= takes the
+// rest of the tag, commas included. NAME_FILE names a file holding the value
+// of a blank NAME, e.g. a Docker secret. Slices and maps are split by
+// envSeparator, "," by default, and map entries by envKeyValSeparator, ":"
+// by default. The fields of a struct with envPrefix are read with the
+// prefix added to their names; a nil pointer to such a struct is left nil
+// unless one of its vars is set or its defaults make it valid. Every missing
+// or invalid var is reported in the returned error.
 func GetFromEnv(obj any) error {
 	// check obj is pointer
 	if reflect.ValueOf(obj).Kind() != reflect.Ptr {
 		return errors.New("obj must be a pointer")
 	}
-
 	v := reflect.ValueOf(obj).Elem()
-	t := v.Type()
+	if v.Kind() != reflect.Struct {
+		return errors.New("obj must be a pointer to a struct")
+	}
+	_, _, errs := getFromEnv(v, "")
+	return errors.Join(errs...)
+}
 
+// getFromEnv also reports whether a var was set and whether a default was
+// used, so that an optional block of a nil pointer can be left nil.
+func getFromEnv(v reflect.Value, prefix string) (set, defaulted bool, errs []error) {
+	t := v.Type()
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
-		envTag := field.Tag.Get("env")
-		if envTag == "" {
+		if !field.IsExported() {
 			continue
 		}
-		var isOptional bool
-		if strings.Contains(envTag, ",") {
-			envTagSlice := strings.Split(envTag, ",")
-			envTag = envTagSlice[0]
-			isOptional = (strings.ToLower(envTagSlice[1]) == _OPTIONAL)
+		fieldValue := v.Field(i)
+		if p, ok := field.Tag.Lookup("envPrefix"); ok {
+			s, d, e := getPrefixFromEnv(field, fieldValue, prefix+p)
+			set, defaulted, errs = set || s, defaulted || d, append(errs, e...)
+			continue
 		}
-		if isOptional {
-			fmt.Println("optional", envTag)
+		envTag := field.Tag.Get("env")
+		if envTag == "" {
+			continue
 		}
+		name, isOptional, defValue, hasDefault := parseEnvTag(envTag)
+		name = prefix + name
 
-		envValue := os.Getenv(envTag)
-		if envValue == "" && isOptional {
+		envValue, err := lookupEnv(name)
+		if err != nil {
+			// the var is set by its file
+			set = true
+			errs = append(errs, err)
 			continue
 		}
+		if envValue != "" {
+			set = true
+		} else if hasDefault {
+			envValue = defValue
+			defaulted = true
+		}
 		if envValue == "" {
-			return errors.New("environmental variable " + envTag + " must not be blank")
+			if !isOptional && !hasDefault {
+				errs = append(errs, errors.New("environmental variable "+name+" must not be blank"))
+			}
+			continue
 		}
 
-		fieldValue := v.Field(i)
-		switch fieldValue.Kind() {
-		case reflect.String:
-			fieldValue.SetString(envValue)
-		case reflect.Int:
-			intValue, err := strconv.Atoi(envValue)
-			if err != nil {
-				return errors.New("environmental variable " + envTag + " must be an integer")
+		sep := field.Tag.Get("envSeparator")
+		if sep == "" {
+			sep = defaultSeparator
+		}
+		kvSep := field.Tag.Get("envKeyValSeparator")
+		if kvSep == "" {
+			kvSep = defaultKeyValSeparator
+		}
+		if err := setValue(fieldValue, envValue, sep, kvSep); err != nil {
+			errs = append(errs, errors.New("environmental variable "+name+" "+err.Error()))
+		}
+	}
+	return set, defaulted, errs
+}
+
+// getPrefixFromEnv sets the struct of an envPrefix field. A nil pointer is
+// only allocated when a var of the prefix is set, or when its defaults make
+// a valid block; an optional block stays nil otherwise.
+func getPrefixFromEnv(field reflect.StructField, v reflect.Value, prefix string) (set, defaulted bool, errs []error) {
+	if v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Struct {
+		if !v.IsNil() {
+			return getFromEnv(v.Elem(), prefix)
+		}
+		p := reflect.New(v.Type().Elem())
+		set, defaulted, errs = getFromEnv(p.Elem(), prefix)
+		if set || (defaulted && len(errs) == 0) {
+			v.Set(p)
+			return set, defaulted, errs
+		}
+		return false, false, nil
+	}
+	if v.Kind() != reflect.Struct {
+		return false, false, []error{errors.New("envPrefix field " + field.Name + " must be a struct")}
+	}
+	return getFromEnv(v, prefix)
+}
+
+// parseEnvTag splits a tag like NAME,opt,default=a,b.
+func parseEnvTag(tag string) (name string, optional bool, defValue string, hasDefault bool) {
+	name, rest, _ := strings.Cut(tag, ",")
+	for rest != "" {
+		if strings.HasPrefix(rest, _DEFAULT) {
+			return name, optional, rest[len(_DEFAULT):], true
+		}
+		var opt string
+		opt, rest, _ = strings.Cut(rest, ",")
+		if strings.ToLower(opt) == _OPTIONAL {
+			optional = true
+		}
+	}
+	return name, optional, "", false
+}
+
+// lookupEnv returns the value of name, read from the file of name_FILE
+// when name is blank.
+func lookupEnv(name string) (string, error) {
+	if v := os.Getenv(name); v != "" {
+		return v, nil
+	}
+	file := os.Getenv(name + _FILE_SUFFIX)
+	if file == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("environmental variable %s%s: %w", name, _FILE_SUFFIX, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// setValue parses s into v. Its error completes "environmental variable X".
+func setValue(v reflect.Value, s, sep, kvSep string) error {
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s, sep, kvSep); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	if reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("is invalid: %v", err)
+		}
+		return nil
+	}
+	switch v.Type() {
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("must be a duration")
+		}
+		v.SetInt(int64(d))
+		return nil
+	case urlType:
+		u, err := url.Parse(s)
+		if err != nil {
+			return errors.New("must be a url")
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer of " + strconv.Itoa(v.Type().Bits()) + " bits")
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be an unsigned integer of " + strconv.Itoa(v.Type().Bits()) + " bits")
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		items := strings.Split(s, sep)
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), strings.TrimSpace(item), sep, kvSep); err != nil {
+				return fmt.Errorf("item [%s] %v", item, err)
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, entry := range strings.Split(s, sep) {
+			key, value, ok := strings.Cut(entry, kvSep)
+			if !ok {
+				return fmt.Errorf("entry [%s] must be key%svalue", entry, kvSep)
 			}
-			fieldValue.SetInt(int64(intValue))
-		case reflect.Bool:
-			boolValue, err := strconv.ParseBool(envValue)
-			if err != nil {
-				return errors.New("environmental variable " + envTag + " must be a boolean")
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := setValue(k, strings.TrimSpace(key), sep, kvSep); err != nil {
+				return fmt.Errorf("key [%s] %v", key, err)
 			}
-			fieldValue.SetBool(boolValue)
-		case reflect.TypeOf(time.Duration(0)).Kind():
-			durationValue, err := time.ParseDuration(envValue)
-			if err != nil {
-				return errors.New("environmental variable " + envTag + " must be a duration")
+			val := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(val, strings.TrimSpace(value), sep, kvSep); err != nil {
+				return fmt.Errorf("value of [%s] %v", key, err)
 			}
-			fieldValue.Set(reflect.ValueOf(durationValue))
-		default:
-			return errors.New("unsupported type: " + fieldValue.Kind().String())
+			m.SetMapIndex(k, val)
 		}
+		v.Set(m)
+	default:
+		return errors.New("has unsupported type: " + v.Type().String())
 	}
 	return nil
 }
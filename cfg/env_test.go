@@ -1,7 +1,11 @@
 package cfg_test
 
 import (
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -57,17 +61,160 @@ func TestGetFromEnv(t *testing.T) {
 	os.Unsetenv("BOOL_ENV_VAR")
 	os.Unsetenv("DURATION_ENV_VAR")
 
-	// Test for unsupported type environmental variable
+	// Test for invalid map environmental variable
 	os.Setenv("MAP_ENV_VAR", "1")
-	type unsupportedTypeStruct struct {
+	defer os.Unsetenv("MAP_ENV_VAR")
+	type mapStruct struct {
 		MapField map[string]string `env:"MAP_ENV_VAR"`
 	}
+	err = cfg.GetFromEnv(&mapStruct{})
+	if err == nil || !strings.Contains(err.Error(), "entry [1] must be key:value") {
+		t.Error("Expected error for invalid map environmental variable")
+	}
+
+	// Test for unsupported type environmental variable
+	type unsupportedTypeStruct struct {
+		ChanField chan int `env:"MAP_ENV_VAR"`
+	}
 	err = cfg.GetFromEnv(&unsupportedTypeStruct{})
 	if err == nil || !strings.Contains(err.Error(), "unsupported type") {
 		t.Error("Expected error for unsupported type environmental variable")
 	}
 }
 
+type dbEnv struct {
+	Host    string        `env:"HOST"`
+	Port    uint16        `env:"PORT,default=5432"`
+	Timeout time.Duration `env:"TIMEOUT,opt"`
+}
+
+type typesEnv struct {
+	Int8     int8              `env:"TYPES_INT8"`
+	Uint64   uint64            `env:"TYPES_UINT64"`
+	Float32  float32           `env:"TYPES_FLOAT32"`
+	Hosts    []string          `env:"TYPES_HOSTS"`
+	Ports    []int             `env:"TYPES_PORTS" envSeparator:";"`
+	Labels   map[string]string `env:"TYPES_LABELS" envKeyValSeparator:"="`
+	Limit    *int              `env:"TYPES_LIMIT"`
+	Missing  *int              `env:"TYPES_MISSING,opt"`
+	Since    time.Time         `env:"TYPES_SINCE"`
+	Endpoint url.URL           `env:"TYPES_ENDPOINT"`
+	IP       net.IP            `env:"TYPES_IP"`
+	Mode     string            `env:"TYPES_MODE,opt,default=a,b"`
+	Password string            `env:"TYPES_PASSWORD"`
+	DB       dbEnv             `envPrefix:"TYPES_DB_"`
+	Replica  *dbEnv            `envPrefix:"TYPES_REPLICA_"`
+}
+
+func TestGetFromEnvTypes(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"TYPES_INT8":          "-8",
+		"TYPES_UINT64":        "18446744073709551615",
+		"TYPES_FLOAT32":       "1.5",
+		"TYPES_HOSTS":         "a, b",
+		"TYPES_PORTS":         "80;443",
+		"TYPES_LABELS":        "env=prod,team=core",
+		"TYPES_LIMIT":         "10",
+		"TYPES_SINCE":         "2024-01-02T03:04:05Z",
+		"TYPES_ENDPOINT":      "https://example.com/api",
+		"TYPES_IP":            "10.0.0.1",
+		"TYPES_PASSWORD_FILE": secret,
+		"TYPES_DB_HOST":       "db",
+		"TYPES_REPLICA_HOST":  "replica",
+		"TYPES_REPLICA_PORT":  "5433",
+	} {
+		t.Setenv(k, v)
+	}
+
+	var c typesEnv
+	if err := cfg.GetFromEnv(&c); err != nil {
+		t.Fatal(err)
+	}
+	want := typesEnv{
+		Int8:     -8,
+		Uint64:   18446744073709551615,
+		Float32:  1.5,
+		Hosts:    []string{"a", "b"},
+		Ports:    []int{80, 443},
+		Labels:   map[string]string{"env": "prod", "team": "core"},
+		Since:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Endpoint: url.URL{Scheme: "https", Host: "example.com", Path: "/api"},
+		IP:       net.ParseIP("10.0.0.1"),
+		Mode:     "a,b",
+		Password: "s3cret",
+		DB:       dbEnv{Host: "db", Port: 5432},
+		Replica:  &dbEnv{Host: "replica", Port: 5433},
+	}
+	if c.Limit == nil || *c.Limit != 10 {
+		t.Errorf("unexpected limit %v", c.Limit)
+	}
+	c.Limit = nil
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("unexpected config\n%+v\nwant\n%+v", c, want)
+	}
+
+	// every invalid or missing variable is reported
+	t.Setenv("TYPES_INT8", "128")
+	t.Setenv("TYPES_PORTS", "80;x")
+	t.Setenv("TYPES_IP", "")
+	t.Setenv("TYPES_DB_PORT", "-1")
+	err := cfg.GetFromEnv(&typesEnv{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, msg := range []string{
+		"TYPES_INT8 must be an integer of 8 bits",
+		"TYPES_PORTS item [x] must be an integer",
+		"TYPES_IP must not be blank",
+		"TYPES_DB_PORT must be an unsigned integer of 16 bits",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q in %q", msg, err.Error())
+		}
+	}
+}
+
+func TestGetFromEnvNilPrefix(t *testing.T) {
+	type cacheEnv struct {
+		TTL time.Duration `env:"TTL,default=1m"`
+	}
+	type nilEnv struct {
+		Replica *dbEnv    `envPrefix:"NIL_REPLICA_"`
+		Cache   *cacheEnv `envPrefix:"NIL_CACHE_"`
+	}
+
+	// a block without vars stays nil, even with a required field
+	var c nilEnv
+	if err := cfg.GetFromEnv(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Replica != nil {
+		t.Errorf("expected nil replica, got %+v", c.Replica)
+	}
+	if c.Cache == nil || c.Cache.TTL != time.Minute {
+		t.Errorf("expected the default cache, got %+v", c.Cache)
+	}
+
+	// a single var allocates the block and checks it
+	t.Setenv("NIL_REPLICA_TIMEOUT", "1s")
+	err := cfg.GetFromEnv(&nilEnv{})
+	if err == nil || !strings.Contains(err.Error(), "NIL_REPLICA_HOST must not be blank") {
+		t.Errorf("expected the missing host, got %v", err)
+	}
+	t.Setenv("NIL_REPLICA_HOST", "replica")
+	c = nilEnv{}
+	if err := cfg.GetFromEnv(&c); err != nil {
+		t.Fatal(err)
+	}
+	if want := (dbEnv{Host: "replica", Port: 5432, Timeout: time.Second}); c.Replica == nil || *c.Replica != want {
+		t.Errorf("expected %+v, got %+v", want, c.Replica)
+	}
+}
+
 func TestGetFromEnvOpt(t *testing.T) {
 	type testStruct struct {
 		StringField string `env:"STRING_ENV_VAR"`
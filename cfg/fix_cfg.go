@@ -2,14 +2,15 @@ package cfg
 
 import (
 	"context"
+	"net/http"
 	"runtime"
 
 	"github.com/94peter/api-toolkit/errors"
+	apiErr "github.com/94peter/microservice/apitool/err"
 	"github.com/94peter/microservice/di"
 	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/requestid"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	pkgErr "github.com/pkg/errors"
 	"google.golang.org/grpc"
 )
 
@@ -19,6 +20,23 @@ func NewFixModelCfgGinMid[T ModelCfg](cfg T) ModelCfgMgr {
 	}
 }
 
+var (
+	// ErrDINotFound is returned when no DI was injected before the model
+	// config, e.g. without the DI middleware or interceptor.
+	ErrDINotFound = apiErr.NewError(http.StatusInternalServerError, "DI_NOT_FOUND", "can not get di")
+	// ErrConfEmpty wraps the IsConfEmpty error of the DI.
+	ErrConfEmpty = apiErr.NewError(http.StatusServiceUnavailable, "CONF_EMPTY", "di config is empty")
+)
+
+// requestID returns the id of the request handled with ctx, so the model
+// config is initialized with the id seen by the client.
+func requestID(ctx context.Context) string {
+	if id := requestid.FromContext(ctx); id != "" {
+		return id
+	}
+	return requestid.New()
+}
+
 type modelCfgMgr[T ModelCfg] struct {
 	cfg T
 	errors.CommonApiErrorHandler
@@ -29,16 +47,16 @@ func (m *modelCfgMgr[T]) Handler() gin.HandlerFunc {
 		data := m.cfg.Copy()
 		servDi := di.GetDiFromGin[di.DI](c)
 		if servDi == nil {
-			m.GinApiErrorHandler(c, pkgErr.New("can not get di"))
+			m.GinApiErrorHandler(c, ErrDINotFound)
 			c.Abort()
 			return
 		}
 		if err := servDi.IsConfEmpty(); err != nil {
-			m.GinApiErrorHandler(c, err)
+			m.GinApiErrorHandler(c, ErrConfEmpty.WithCause(err))
 			c.Abort()
 			return
 		}
-		if err := data.Init(uuid.New().String(), servDi); err != nil {
+		if err := data.Init(requestID(c), servDi); err != nil {
 			m.GinApiErrorHandler(c, err)
 			c.Abort()
 			return
@@ -60,12 +78,12 @@ func (m *modelCfgMgr[T]) StreamServerInterceptor() grpc.StreamServerInterceptor
 		data := m.cfg.Copy()
 		servDi := di.GetDiFromCtx[di.DI](ctx)
 		if servDi == nil {
-			return pkgErr.New("can not get di")
+			return ErrDINotFound
 		}
 		if err := servDi.IsConfEmpty(); err != nil {
-			return err
+			return ErrConfEmpty.WithCause(err)
 		}
-		if err := data.Init(uuid.New().String(), servDi); err != nil {
+		if err := data.Init(requestID(ctx), servDi); err != nil {
 			return err
 		}
 
@@ -85,12 +103,12 @@ func (m *modelCfgMgr[T]) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 		data := m.cfg.Copy()
 		servDi := di.GetDiFromCtx[di.DI](ctx)
 		if servDi == nil {
-			return nil, pkgErr.New("can not get di")
+			return nil, ErrDINotFound
 		}
 		if err := servDi.IsConfEmpty(); err != nil {
-			return nil, err
+			return nil, ErrConfEmpty.WithCause(err)
 		}
-		if err := data.Init(uuid.New().String(), servDi); err != nil {
+		if err := data.Init(requestID(ctx), servDi); err != nil {
 			return nil, err
 		}
 		defer func() {
@@ -0,0 +1,157 @@
+package configsource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHTTPTimeout  = 10 * time.Second
+	defaultPollInterval = 30 * time.Second
+	maxErrorBody        = 512
+)
+
+type HTTPOption func(*HTTPSource)
+
+// WithHeader sets a header of the requests, e.g. an api key.
+func WithHeader(key, value string) HTTPOption {
+	return func(s *HTTPSource) {
+		s.header.Set(key, value)
+	}
+}
+
+func WithBearerToken(token string) HTTPOption {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+func WithBasicAuth(user, password string) HTTPOption {
+	return func(s *HTTPSource) {
+		s.user, s.password = user, password
+	}
+}
+
+// WithTimeout limits every request, 10s by default.
+func WithTimeout(d time.Duration) HTTPOption {
+	return func(s *HTTPSource) {
+		s.timeout = d
+	}
+}
+
+// WithPollInterval sets the interval of Watch, 30s by default.
+func WithPollInterval(d time.Duration) HTTPOption {
+	return func(s *HTTPSource) {
+		s.interval = d
+	}
+}
+
+// WithHTTPClient sends the requests with c, e.g. for mTLS.
+func WithHTTPClient(c *http.Client) HTTPOption {
+	return func(s *HTTPSource) {
+		s.client = c
+	}
+}
+
+// HTTPSource gets a document over HTTP(S). It sends If-None-Match with the
+// ETag of the last response, so an unchanged document is not downloaded
+// again.
+type HTTPSource struct {
+	url      string
+	header   http.Header
+	user     string
+	password string
+	timeout  time.Duration
+	interval time.Duration
+	client   *http.Client
+
+	mu   sync.Mutex
+	etag string
+	body []byte
+}
+
+func NewHTTPSource(url string, opts ...HTTPOption) *HTTPSource {
+	s := &HTTPSource{
+		url:      url,
+		header:   make(http.Header),
+		timeout:  defaultHTTPTimeout,
+		interval: defaultPollInterval,
+		client:   http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *HTTPSource) Load(ctx context.Context) ([]byte, error) {
+	b, _, err := s.fetch(ctx)
+	return b, err
+}
+
+// Watch polls the document. A failed poll is retried at the next interval.
+func (s *HTTPSource) Watch(ctx context.Context, onChange func()) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, changed, err := s.fetch(ctx); err == nil && changed {
+				onChange()
+			}
+		}
+	}
+}
+
+func (s *HTTPSource) String() string {
+	return s.url
+}
+
+// fetch returns the document and tells if it changed since the last fetch.
+func (s *HTTPSource) fetch(ctx context.Context) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	s.mu.Lock()
+	etag, cached := s.etag, s.body
+	s.mu.Unlock()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("get config %s fail: %w", s.url, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached, false, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, false, fmt.Errorf("%w: %s", ErrNotFound, s.url)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, false, fmt.Errorf("get config %s fail: %s: %s", s.url, resp.Status, bytes.TrimSpace(msg))
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, fmt.Errorf("read config %s fail: %w", s.url, err)
+	}
+	s.mu.Lock()
+	s.etag, s.body = resp.Header.Get("ETag"), b
+	s.mu.Unlock()
+	return b, cached == nil || !bytes.Equal(cached, b), nil
+}
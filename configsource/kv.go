@@ -0,0 +1,188 @@
+package configsource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const kvRetryInterval = 5 * time.Second
+
+// KVStore is a key value backend like Consul or etcd.
+type KVStore interface {
+	// Get returns the value of key and its modify index. With index above
+	// zero it blocks until the key changes after index or the backend times
+	// out, as Consul blocking queries do. A missing key is ErrNotFound.
+	Get(ctx context.Context, key string, index uint64) ([]byte, uint64, error)
+}
+
+// KVSource reads the document of a key of a KVStore.
+type KVSource struct {
+	store KVStore
+	key   string
+
+	mu    sync.Mutex
+	index uint64
+	value []byte
+}
+
+func NewKVSource(store KVStore, key string) *KVSource {
+	return &KVSource{store: store, key: key}
+}
+
+func (s *KVSource) Load(ctx context.Context) ([]byte, error) {
+	b, index, err := s.store.Get(ctx, s.key, 0)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.index, s.value = index, b
+	s.mu.Unlock()
+	return b, nil
+}
+
+// Watch waits for the changes of the key. A failed wait is retried after 5s.
+func (s *KVSource) Watch(ctx context.Context, onChange func()) error {
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		index, value := s.index, s.value
+		s.mu.Unlock()
+		if index == 0 {
+			// not loaded yet or reset, the value read is not a change
+			if _, err := s.Load(ctx); err != nil || s.loadedIndex() == 0 {
+				sleep(ctx, kvRetryInterval)
+			}
+			continue
+		}
+		b, next, err := s.store.Get(ctx, s.key, index)
+		if err != nil {
+			sleep(ctx, kvRetryInterval)
+			continue
+		}
+		// the index goes backwards when the store is reset
+		if next < index {
+			next = 0
+		}
+		s.mu.Lock()
+		s.index, s.value = next, b
+		s.mu.Unlock()
+		if !bytes.Equal(b, value) {
+			onChange()
+		}
+	}
+	return nil
+}
+
+func (s *KVSource) loadedIndex() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.index
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+func (s *KVSource) String() string {
+	return fmt.Sprintf("%s key %s", s.store, s.key)
+}
+
+const defaultConsulWait = 5 * time.Minute
+
+// ConsulStore is a KVStore of the Consul HTTP api.
+type ConsulStore struct {
+	addr   string
+	token  string
+	wait   time.Duration
+	client *http.Client
+}
+
+type ConsulOption func(*ConsulStore)
+
+// WithConsulToken sends the ACL token of the requests.
+func WithConsulToken(token string) ConsulOption {
+	return func(s *ConsulStore) {
+		s.token = token
+	}
+}
+
+// WithConsulWait sets the longest wait of a blocking query, 5m by default.
+func WithConsulWait(d time.Duration) ConsulOption {
+	return func(s *ConsulStore) {
+		s.wait = d
+	}
+}
+
+func WithConsulClient(c *http.Client) ConsulOption {
+	return func(s *ConsulStore) {
+		s.client = c
+	}
+}
+
+// NewConsulStore reads the keys of the agent at addr, e.g.
+// http://127.0.0.1:8500.
+func NewConsulStore(addr string, opts ...ConsulOption) *ConsulStore {
+	s := &ConsulStore{
+		addr:   strings.TrimSuffix(addr, "/"),
+		wait:   defaultConsulWait,
+		client: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *ConsulStore) Get(ctx context.Context, key string, index uint64) ([]byte, uint64, error) {
+	query := url.Values{"raw": {""}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", s.wait.String())
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultHTTPTimeout)
+		defer cancel()
+	}
+	u := s.addr + "/v1/kv/" + strings.TrimPrefix(key, "/") + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get consul key %s fail: %w", key, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, 0, fmt.Errorf("%w: consul key %s", ErrNotFound, key)
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return nil, 0, fmt.Errorf("get consul key %s fail: %s: %s", key, resp.Status, bytes.TrimSpace(msg))
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read consul key %s fail: %w", key, err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return b, next, nil
+}
+
+func (s *ConsulStore) String() string {
+	return "consul " + s.addr
+}
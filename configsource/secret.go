@@ -0,0 +1,94 @@
+package configsource
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// SecretProvider returns the secrets of the placeholders ${NAME:ref} of a
+// config, NAME being the name the provider is registered with.
+type SecretProvider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+type SecretProviderFunc func(ctx context.Context, ref string) (string, error)
+
+func (f SecretProviderFunc) Secret(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// EnvSecrets resolves ${ENV:NAME} with the env var NAME.
+var EnvSecrets SecretProvider = SecretProviderFunc(func(_ context.Context, ref string) (string, error) {
+	v, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("env var %s is not set", ref)
+	}
+	return v, nil
+})
+
+// FileSecrets resolves ${FILE:/run/secrets/x} with the content of the file
+// without its trailing newline.
+var FileSecrets SecretProvider = SecretProviderFunc(func(_ context.Context, ref string) (string, error) {
+	b, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+})
+
+var placeholder = regexp.MustCompile(`\$?\$\{([A-Za-z][A-Za-z0-9_]*):([^}]*)\}`)
+
+// SecretResolver replaces the placeholders of config values with the
+// secrets of its providers. $${ escapes a literal ${.
+type SecretResolver struct {
+	providers map[string]SecretProvider
+}
+
+// NewSecretResolver resolves ENV and FILE placeholders.
+func NewSecretResolver() *SecretResolver {
+	r := &SecretResolver{providers: make(map[string]SecretProvider)}
+	r.Register("ENV", EnvSecrets)
+	r.Register("FILE", FileSecrets)
+	return r
+}
+
+// Register resolves the ${name:ref} placeholders with p. Names are case
+// insensitive.
+func (r *SecretResolver) Register(name string, p SecretProvider) {
+	r.providers[strings.ToUpper(name)] = p
+}
+
+// Resolve replaces the placeholders of s. resolved tells if s had any.
+func (r *SecretResolver) Resolve(ctx context.Context, s string) (result string, resolved bool, err error) {
+	if !strings.Contains(s, "${") {
+		return s, false, nil
+	}
+	result = placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		if err != nil {
+			return m
+		}
+		if strings.HasPrefix(m, "$$") {
+			return m[1:]
+		}
+		sub := placeholder.FindStringSubmatch(m)
+		p, ok := r.providers[strings.ToUpper(sub[1])]
+		if !ok {
+			err = fmt.Errorf("unknown secret provider %s", sub[1])
+			return m
+		}
+		v, perr := p.Secret(ctx, sub[2])
+		if perr != nil {
+			err = fmt.Errorf("resolve ${%s:%s} fail: %w", sub[1], sub[2], perr)
+			return m
+		}
+		resolved = true
+		return v
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return result, resolved, nil
+}
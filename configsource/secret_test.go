@@ -0,0 +1,72 @@
+package configsource
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretResolver(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(file, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DB_USER", "admin")
+
+	r := NewSecretResolver()
+	v, resolved, err := r.Resolve(context.Background(), "postgres://${env:DB_USER}:${FILE:"+file+"}@db")
+	if err != nil || !resolved || v != "postgres://admin:s3cret@db" {
+		t.Errorf("unexpected %q, %v, %v", v, resolved, err)
+	}
+	v, resolved, err = r.Resolve(context.Background(), "$${ENV:DB_USER} and plain")
+	if err != nil || resolved || v != "${ENV:DB_USER} and plain" {
+		t.Errorf("unexpected %q, %v, %v", v, resolved, err)
+	}
+	if _, _, err := r.Resolve(context.Background(), "${ENV:MISSING_SECRET_VAR}"); err == nil {
+		t.Error("expected error of a missing env var")
+	}
+	if _, _, err := r.Resolve(context.Background(), "${AWS:x}"); err == nil || !strings.Contains(err.Error(), "unknown secret provider AWS") {
+		t.Errorf("expected unknown provider error, got %v", err)
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/db":
+			w.Write([]byte(`{"data":{"data":{"password":"kv2","port":5432},"metadata":{"version":1}}}`))
+		case "/v1/kv1/db":
+			w.Write([]byte(`{"data":{"password":"kv1"}}`))
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	r := NewSecretResolver()
+	r.Register("vault", NewVaultProvider(srv.URL, "root"))
+	v, _, err := r.Resolve(context.Background(), "${VAULT:secret/data/db#password}:${VAULT:secret/data/db#port}/${VAULT:kv1/db#password}")
+	if err != nil || v != "kv2:5432/kv1" {
+		t.Errorf("unexpected %q, %v", v, err)
+	}
+	_, err = NewVaultProvider(srv.URL, "root").Secret(context.Background(), "secret/data/missing#password")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	_, err = NewVaultProvider(srv.URL, "bad").Secret(context.Background(), "secret/data/db#password")
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden") {
+		t.Errorf("expected status error, got %v", err)
+	}
+	if _, err := NewVaultProvider(srv.URL, "root").Secret(context.Background(), "secret/data/db"); err == nil {
+		t.Error("expected error of a ref without key")
+	}
+}
@@ -0,0 +1,101 @@
+// Package configsource provides config documents from files, Kubernetes
+// ConfigMap volumes, HTTP endpoints and KV stores like Consul.
+package configsource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/94peter/microservice/internal/filewatch"
+	"github.com/spf13/viper"
+)
+
+// ErrNotFound tells a source has no document.
+var ErrNotFound = errors.New("config not found")
+
+// ConfigSource provides a config document.
+type ConfigSource interface {
+	// Load returns the document, an error wrapping ErrNotFound when there
+	// is none.
+	Load(ctx context.Context) ([]byte, error)
+	// Watch calls onChange when the document may have changed until ctx is
+	// done.
+	Watch(ctx context.Context, onChange func()) error
+	// String names the source in errors.
+	String() string
+}
+
+// FileSource reads a file.
+type FileSource struct {
+	path string
+	name string
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: path, name: path}
+}
+
+// NewConfigMapSource reads key of a ConfigMap mounted at dir. Its changes
+// are noticed through the symlink swap of the volume.
+func NewConfigMapSource(dir, key string) *FileSource {
+	path := filepath.Join(dir, key)
+	return &FileSource{path: path, name: "configmap " + path}
+}
+
+func (s *FileSource) Path() string {
+	return s.path
+}
+
+func (s *FileSource) Load(context.Context) ([]byte, error) {
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, s.path)
+	}
+	return b, err
+}
+
+func (s *FileSource) Watch(ctx context.Context, onChange func()) error {
+	return filewatch.Watch(ctx, []string{s.path}, onChange)
+}
+
+func (s *FileSource) String() string {
+	return s.name
+}
+
+// BytesSource is a document in memory.
+type BytesSource []byte
+
+func (s BytesSource) Load(context.Context) ([]byte, error) {
+	return s, nil
+}
+
+// Watch waits for ctx, the document never changes.
+func (s BytesSource) Watch(ctx context.Context, _ func()) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (s BytesSource) String() string {
+	return "bytes"
+}
+
+// ReadViper reads the document of src into v, the global viper when nil.
+// configType is the format of the document, e.g. yaml.
+func ReadViper(ctx context.Context, v *viper.Viper, src ConfigSource, configType string) error {
+	if v == nil {
+		v = viper.GetViper()
+	}
+	b, err := src.Load(ctx)
+	if err != nil {
+		return err
+	}
+	v.SetConfigType(configType)
+	if err := v.ReadConfig(bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("read config of %s fail: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package configsource
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestHTTPSource(t *testing.T) {
+	var mu sync.Mutex
+	doc, version, gets := "api:\n  port: 8080\n", 1, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		etag := `"` + strconv.Itoa(version) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		gets++
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(doc))
+	}))
+	defer srv.Close()
+
+	_, err := NewHTTPSource(srv.URL).Load(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "401 Unauthorized: no token") {
+		t.Errorf("expected status error, got %v", err)
+	}
+	_, err = NewHTTPSource(srv.URL+"/missing", WithBearerToken("secret"), WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: http.NoBody}, nil
+		}),
+	})).Load(context.Background())
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	src := NewHTTPSource(srv.URL, WithBearerToken("secret"), WithPollInterval(20*time.Millisecond))
+	v := viper.New()
+	if err := ReadViper(context.Background(), v, src, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if port := v.GetInt("api.port"); port != 8080 {
+		t.Errorf("expected port 8080, got %d", port)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	go src.Watch(ctx, func() { changed <- struct{}{} })
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	if gets != 1 {
+		t.Errorf("expected unchanged document to be fetched once, got %d", gets)
+	}
+	doc, version = "api:\n  port: 9090\n", 2
+	mu.Unlock()
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not noticed")
+	}
+	b, err := src.Load(ctx)
+	if err != nil || string(b) != "api:\n  port: 9090\n" {
+		t.Errorf("unexpected document %q, %v", b, err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// fakeConsul serves the kv api of Consul with blocking queries.
+type fakeConsul struct {
+	mu      sync.Mutex
+	changed chan struct{}
+	index   uint64
+	values  map[string]string
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{changed: make(chan struct{}), index: 1, values: make(map[string]string)}
+}
+
+func (f *fakeConsul) put(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	f.values[key] = value
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != "acl" {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	f.mu.Lock()
+	for wait > 0 && f.index <= wait {
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		f.mu.Lock()
+	}
+	value, ok := f.values[key]
+	index := f.index
+	f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write([]byte(value))
+}
+
+func TestKVSource(t *testing.T) {
+	consul := newFakeConsul()
+	srv := httptest.NewServer(consul)
+	defer srv.Close()
+
+	_, err := NewKVSource(NewConsulStore(srv.URL, WithConsulToken("acl")), "app/config").Load(context.Background())
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	_, err = NewKVSource(NewConsulStore(srv.URL), "app/config").Load(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden") {
+		t.Errorf("expected status error, got %v", err)
+	}
+
+	consul.put("app/config", "name: v1\n")
+	src := NewKVSource(NewConsulStore(srv.URL, WithConsulToken("acl")), "app/config")
+	b, err := src.Load(context.Background())
+	if err != nil || string(b) != "name: v1\n" {
+		t.Fatalf("unexpected value %q, %v", b, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	go src.Watch(ctx, func() { changed <- struct{}{} })
+	// another key does not change the document
+	consul.put("app/other", "x")
+	consul.put("app/config", "name: v2\n")
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not noticed")
+	}
+	if b, _ := src.Load(ctx); string(b) != "name: v2\n" {
+		t.Errorf("unexpected value %q", b)
+	}
+	select {
+	case <-changed:
+		t.Error("unexpected change")
+	default:
+	}
+}
+
+func TestConfigMapSource(t *testing.T) {
+	// a ConfigMap volume links the keys through the ..data symlink
+	dir := t.TempDir()
+	write := func(data, content string) {
+		if err := os.MkdirAll(filepath.Join(dir, data), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, data, "app.yaml"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		tmp := filepath.Join(dir, "..data_tmp")
+		if err := os.Symlink(data, tmp); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("..v1", "name: v1\n")
+	if err := os.Symlink(filepath.Join("..data", "app.yaml"), filepath.Join(dir, "app.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	src := NewConfigMapSource(dir, "app.yaml")
+	if b, err := src.Load(context.Background()); err != nil || string(b) != "name: v1\n" {
+		t.Fatalf("unexpected document %q, %v", b, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	go src.Watch(ctx, func() { changed <- struct{}{} })
+	time.Sleep(100 * time.Millisecond)
+	write("..v2", "name: v2\n")
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not noticed")
+	}
+	if b, _ := src.Load(ctx); string(b) != "name: v2\n" {
+		t.Errorf("unexpected document %q", b)
+	}
+	if _, err := NewConfigMapSource(dir, "missing.yaml").Load(ctx); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
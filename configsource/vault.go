@@ -0,0 +1,100 @@
+package configsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type VaultOption func(*VaultProvider)
+
+// WithVaultNamespace sets the namespace of Vault Enterprise.
+func WithVaultNamespace(ns string) VaultOption {
+	return func(p *VaultProvider) {
+		p.namespace = ns
+	}
+}
+
+func WithVaultClient(c *http.Client) VaultOption {
+	return func(p *VaultProvider) {
+		p.client = c
+	}
+}
+
+// VaultProvider is a SecretProvider of the Vault HTTP api. Refs are the
+// secret path and its key, e.g. secret/data/db#password; KV version 2
+// secrets are unwrapped.
+type VaultProvider struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func NewVaultProvider(addr, token string, opts ...VaultOption) *VaultProvider {
+	p := &VaultProvider{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+		client: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *VaultProvider) Secret(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("vault ref [%s] is not path#key", ref)
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultHTTPTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("get vault secret %s fail: %w", path, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%w: vault secret %s", ErrNotFound, path)
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return "", fmt.Errorf("get vault secret %s fail: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode vault secret %s fail: %w", path, err)
+	}
+	data := body.Data
+	// KV version 2 nests the secret in data.data
+	if nested, ok := data["data"]; ok {
+		var kv map[string]json.RawMessage
+		if json.Unmarshal(nested, &kv) == nil && kv != nil {
+			data = kv
+		}
+	}
+	raw, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("%w: vault secret %s has no key %s", ErrNotFound, path, key)
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+	return string(raw), nil
+}
@@ -3,15 +3,15 @@ package di
 import (
 	"context"
 	"fmt"
-	"io"
-	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/94peter/log"
+	"github.com/94peter/microservice/configsource"
+	"github.com/94peter/microservice/health"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-
-	yaml "gopkg.in/yaml.v3"
 )
 
 type ctxKey string
@@ -35,6 +35,9 @@ type ServiceDI interface {
 
 type CommonServiceDI struct {
 	service string
+	// secretPaths are the key paths of the values resolved from secret
+	// placeholders by the last load.
+	secretPaths [][]string
 }
 
 func (s *CommonServiceDI) GetService() string {
@@ -45,6 +48,29 @@ func (impl *CommonServiceDI) setService(s string) {
 	impl.service = s
 }
 
+func (impl *CommonServiceDI) setSecretPaths(paths [][]string) {
+	impl.secretPaths = paths
+}
+
+func (impl *CommonServiceDI) getSecretPaths() [][]string {
+	return impl.secretPaths
+}
+
+// HealthChecker checks that the DI config is loaded and, when the DI
+// implements health.Checkable, that its connections are healthy.
+func HealthChecker(di DI) health.Checker {
+	return func(ctx context.Context) error {
+		di := Current(di)
+		if err := di.IsConfEmpty(); err != nil {
+			return err
+		}
+		if c, ok := di.(health.Checkable); ok {
+			return c.HealthCheck(ctx)
+		}
+		return nil
+	}
+}
+
 func SetDiToCtx[T DI](ctx context.Context, di T) context.Context {
 	return context.WithValue(ctx, _CTX_DI, di)
 }
@@ -75,19 +101,13 @@ func GetDiFromGin[T DI](c *gin.Context) T {
 }
 
 func InitConfByFile(f string, di DI) error {
-	yamlFile, err := os.ReadFile(f)
-	if err != nil {
-		return errors.New("load conf fail: " + f)
-	}
-	return InitConfByByte(yamlFile, di)
+	return NewLoader(f).Load(di)
 }
 
+// InitConfByByte loads di from the YAML b with the placeholders of secrets
+// resolved.
 func InitConfByByte(b []byte, di DI) error {
-	err := yaml.Unmarshal(b, di)
-	if err != nil {
-		return err
-	}
-	return nil
+	return InitConfBySource(context.Background(), configsource.BytesSource(b), di)
 }
 
 const confFileTpl = "%s%s/%s"
@@ -102,11 +122,11 @@ func InitConf(path, fname string, di DI) error {
 }
 
 func InitConfByCfg(cfg *config, di DI) error {
-	return InitConfByFile(cfg.File, di)
+	return cfg.loader().Load(di)
 }
 
 func InitServiceDIByCfg(cfg *config, di ServiceDI) error {
-	err := InitConfByFile(cfg.File, di)
+	err := cfg.loader().Load(di)
 	if err != nil {
 		return err
 	}
@@ -119,38 +139,94 @@ func InitServiceDIByCfg(cfg *config, di ServiceDI) error {
 	return nil
 }
 
+// InitConfByUri loads di from the HTTP(S) uri.
 func InitConfByUri(uri string, di DI) error {
-	resp, err := http.Get(uri)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return errors.Wrap(err, "read conf fail")
-	}
-	err = yaml.Unmarshal(body, di)
-	if err != nil {
-		return err
-	}
-	return nil
+	return InitConfBySource(context.Background(), configsource.NewHTTPSource(uri), di)
+}
+
+func InitConfBySource(ctx context.Context, src configsource.ConfigSource, di DI) error {
+	return NewSourceLoader(src).LoadContext(ctx, di)
 }
 
 type config struct {
-	Service string
-	File    string
+	Service   string
+	Source    configsource.ConfigSource
+	Env       string
+	EnvPrefix string
+	// Secrets are the secret providers by placeholder name.
+	Secrets map[string]configsource.SecretProvider
+}
+
+// NewConfig loads the DI of service from src.
+func NewConfig(service string, src configsource.ConfigSource) *config {
+	return &config{Service: service, Source: src}
 }
 
+// GetConfigFromEnv reads SERVICE and CONFIG_FILE. CONFIG_FILE is a file
+// path, an http(s) url or a consul://host:port/key url; CONFIG_TOKEN is
+// sent as bearer token or Consul ACL token. CONFIG_ENV optionally selects
+// the overlay of a file and CONFIG_ENV_PREFIX the prefix of the env vars
+// overriding its keys. With VAULT_ADDR and VAULT_TOKEN the ${VAULT:path#key}
+// placeholders are resolved by Vault.
 func GetConfigFromEnv() (*config, error) {
 	cfg := config{
-		Service: os.Getenv("SERVICE"),
-		File:    os.Getenv("CONFIG_FILE"),
+		Service:   os.Getenv("SERVICE"),
+		Env:       os.Getenv("CONFIG_ENV"),
+		EnvPrefix: os.Getenv("CONFIG_ENV_PREFIX"),
 	}
 	if cfg.Service == "" {
 		return nil, errors.New("SERVICE is empty")
 	}
-	if cfg.File == "" {
+	file := os.Getenv("CONFIG_FILE")
+	if file == "" {
 		return nil, errors.New("CONFIG_FILE is empty")
 	}
+	src, err := newSource(file, os.Getenv("CONFIG_TOKEN"))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Source = src
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		cfg.Secrets = map[string]configsource.SecretProvider{
+			"VAULT": configsource.NewVaultProvider(addr, os.Getenv("VAULT_TOKEN")),
+		}
+	}
 	return &cfg, nil
 }
+
+func newSource(file, token string) (configsource.ConfigSource, error) {
+	switch {
+	case strings.HasPrefix(file, "http://"), strings.HasPrefix(file, "https://"):
+		var opts []configsource.HTTPOption
+		if token != "" {
+			opts = append(opts, configsource.WithBearerToken(token))
+		}
+		return configsource.NewHTTPSource(file, opts...), nil
+	case strings.HasPrefix(file, "consul://"):
+		u, err := url.Parse(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid CONFIG_FILE")
+		}
+		var opts []configsource.ConsulOption
+		if token != "" {
+			opts = append(opts, configsource.WithConsulToken(token))
+		}
+		store := configsource.NewConsulStore("http://"+u.Host, opts...)
+		return configsource.NewKVSource(store, strings.TrimPrefix(u.Path, "/")), nil
+	}
+	return configsource.NewFileSource(file), nil
+}
+
+func (cfg *config) loader() *Loader {
+	var opts []LoaderOption
+	if cfg.Env != "" {
+		opts = append(opts, WithEnvOverlay(cfg.Env))
+	}
+	if cfg.EnvPrefix != "" {
+		opts = append(opts, WithEnvPrefix(cfg.EnvPrefix))
+	}
+	for name, p := range cfg.Secrets {
+		opts = append(opts, WithSecretProvider(name, p))
+	}
+	return NewSourceLoader(cfg.Source, opts...)
+}
@@ -0,0 +1,596 @@
+package di
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/94peter/microservice/configsource"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Layers of a config, from the lowest to the highest precedence.
+const (
+	LayerDefault = "default"
+	LayerBase    = "base"
+	LayerOverlay = "overlay"
+	LayerEnv     = "env"
+)
+
+// ConfError is an error of the config key Path. Layer and Source tell where
+// the value came from: the file, with its Line, or the env var.
+type ConfError struct {
+	Layer  string
+	Source string
+	Line   int
+	Path   string
+	Err    error
+}
+
+func (e *ConfError) Error() string {
+	var b strings.Builder
+	b.WriteString("config")
+	if e.Path != "" {
+		fmt.Fprintf(&b, " [%s]", e.Path)
+	}
+	if e.Layer != "" {
+		fmt.Fprintf(&b, " from %s", e.Layer)
+		if e.Source != "" {
+			fmt.Fprintf(&b, " %s", e.Source)
+			if e.Line > 0 {
+				fmt.Fprintf(&b, ":%d", e.Line)
+			}
+		}
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ConfError) Unwrap() error {
+	return e.Err
+}
+
+// ConfErrors are all the errors found while loading a config.
+type ConfErrors []*ConfError
+
+func (e ConfErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+type origin struct {
+	layer  string
+	source string
+}
+
+type LoaderOption func(*Loader)
+
+type layerSource struct {
+	layer string
+	src   configsource.ConfigSource
+}
+
+// WithOverlay merges file over the base file. A missing overlay is skipped.
+func WithOverlay(file string) LoaderOption {
+	return WithOverlaySource(configsource.NewFileSource(file))
+}
+
+// WithOverlaySource merges the document of src over the base one. A
+// missing document is skipped.
+func WithOverlaySource(src configsource.ConfigSource) LoaderOption {
+	return func(l *Loader) {
+		l.sources = append(l.sources, layerSource{layer: LayerOverlay, src: src})
+	}
+}
+
+// WithEnvOverlay merges the file of env, found with the IniConfByEnv
+// pattern: config/app.yaml has the overlay config/<env>/app.yaml. It only
+// applies to a base file.
+func WithEnvOverlay(env string) LoaderOption {
+	return func(l *Loader) {
+		f, ok := l.sources[0].src.(*configsource.FileSource)
+		if !ok {
+			return
+		}
+		dir, fname := filepath.Split(f.Path())
+		WithOverlay(fmt.Sprintf(confFileTpl, dir, env, fname))(l)
+	}
+}
+
+// WithSecretProvider resolves the ${name:ref} placeholders of the config
+// values with p. ENV and FILE placeholders are always resolved.
+func WithSecretProvider(name string, p configsource.SecretProvider) LoaderOption {
+	return func(l *Loader) {
+		l.secrets.Register(name, p)
+	}
+}
+
+// WithEnvPrefix overrides every config key with the env var named by
+// prefix and the upper case key path, e.g. APP_DB_URL for db.url. Fields
+// with an env tag are always overridden by that var.
+func WithEnvPrefix(prefix string) LoaderOption {
+	return func(l *Loader) {
+		l.envPrefix = prefix
+	}
+}
+
+// Loader loads a DI from layers: the default tags of its fields, the base
+// document, the overlays and the env vars, each one merged over the
+// previous ones key by key. The placeholders of secrets in the values are
+// resolved and the result is validated with the validate tags.
+type Loader struct {
+	sources   []layerSource
+	envPrefix string
+	secrets   *configsource.SecretResolver
+}
+
+// NewLoader loads the base file.
+func NewLoader(base string, opts ...LoaderOption) *Loader {
+	return NewSourceLoader(configsource.NewFileSource(base), opts...)
+}
+
+// NewSourceLoader loads the base document of src.
+func NewSourceLoader(base configsource.ConfigSource, opts ...LoaderOption) *Loader {
+	l := &Loader{
+		sources: []layerSource{{layer: LayerBase, src: base}},
+		secrets: configsource.NewSecretResolver(),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Sources returns the base source and the overlay ones.
+func (l *Loader) Sources() []configsource.ConfigSource {
+	result := make([]configsource.ConfigSource, len(l.sources))
+	for i, s := range l.sources {
+		result[i] = s.src
+	}
+	return result
+}
+
+func (l *Loader) Load(di DI) error {
+	return l.LoadContext(context.Background(), di)
+}
+
+func (l *Loader) LoadContext(ctx context.Context, di DI) error {
+	t := reflect.TypeOf(di)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return errors.New("di must be a pointer")
+	}
+	root, origins, secrets, err := l.load(ctx, t.Elem())
+	if err != nil {
+		return err
+	}
+	if err := root.Decode(di); err != nil {
+		return &ConfError{Layer: LayerBase, Source: l.sources[0].src.String(), Err: err}
+	}
+	rememberSecrets(di, root, secrets)
+	if t.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	return validate(di, root, origins)
+}
+
+// load merges the layers of a config of type t in a document. secrets are
+// the values with resolved placeholders.
+func (l *Loader) load(ctx context.Context, t reflect.Type) (root *yaml.Node, origins map[*yaml.Node]origin, secrets map[*yaml.Node]bool, err error) {
+	origins = make(map[*yaml.Node]origin)
+	secrets = make(map[*yaml.Node]bool)
+	var errs ConfErrors
+	root = &yaml.Node{Kind: yaml.MappingNode}
+	walkFields(t, nil, func(path []string, f reflect.StructField) {
+		v, ok := f.Tag.Lookup("default")
+		if !ok {
+			return
+		}
+		o := origin{layer: LayerDefault, source: "default tag of " + f.Name}
+		n, err := valueNode(f.Type, v)
+		if err != nil {
+			errs = append(errs, &ConfError{Layer: o.layer, Source: o.source, Path: strings.Join(path, "."), Err: err})
+			return
+		}
+		if resolveErrs := l.resolveSecrets(ctx, n, strings.Join(path, "."), o, secrets); len(resolveErrs) > 0 {
+			errs = append(errs, resolveErrs...)
+			return
+		}
+		mark(n, o, origins)
+		setPath(root, path, n)
+	})
+
+	for _, ls := range l.sources {
+		o := origin{layer: ls.layer, source: ls.src.String()}
+		b, err := ls.src.Load(ctx)
+		if err != nil {
+			if ls.layer == LayerOverlay && errors.Is(err, configsource.ErrNotFound) {
+				continue
+			}
+			errs = append(errs, &ConfError{Layer: o.layer, Source: o.source, Err: err})
+			continue
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			errs = append(errs, &ConfError{Layer: o.layer, Source: o.source, Err: err})
+			continue
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		n := doc.Content[0]
+		if resolveErrs := l.resolveSecrets(ctx, n, "", o, secrets); len(resolveErrs) > 0 {
+			errs = append(errs, resolveErrs...)
+			continue
+		}
+		if layerErrs := checkTypes(t, n, o); len(layerErrs) > 0 {
+			errs = append(errs, layerErrs...)
+			continue
+		}
+		mark(n, o, origins)
+		root = merge(root, n)
+	}
+
+	walkFields(t, nil, func(path []string, f reflect.StructField) {
+		name := l.envName(path, f)
+		if name == "" {
+			return
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		o := origin{layer: LayerEnv, source: name}
+		n, err := valueNode(f.Type, v)
+		if err == nil {
+			if resolveErrs := l.resolveSecrets(ctx, n, strings.Join(path, "."), o, secrets); len(resolveErrs) > 0 {
+				errs = append(errs, resolveErrs...)
+				return
+			}
+			err = n.Decode(reflect.New(f.Type).Interface())
+		}
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			// the node of an env var has no line
+			msgs := make([]string, len(typeErr.Errors))
+			for i, msg := range typeErr.Errors {
+				msgs[i] = typeErrorLine.ReplaceAllString(msg, "$2")
+			}
+			err = errors.New(strings.Join(msgs, ", "))
+		}
+		if err != nil {
+			errs = append(errs, &ConfError{Layer: o.layer, Source: name, Path: strings.Join(path, "."), Err: err})
+			return
+		}
+		mark(n, o, origins)
+		setPath(root, path, n)
+	})
+	if len(errs) > 0 {
+		return nil, nil, nil, errs
+	}
+	return root, origins, secrets, nil
+}
+
+var envNameReplacer = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func (l *Loader) envName(path []string, f reflect.StructField) string {
+	if tag := f.Tag.Get("env"); tag != "" {
+		return strings.Split(tag, ",")[0]
+	}
+	if l.envPrefix == "" {
+		return ""
+	}
+	name := envNameReplacer.ReplaceAllString(strings.Join(path, "_"), "_")
+	return strings.ToUpper(l.envPrefix + "_" + name)
+}
+
+var (
+	yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// walkFields calls fn with the key path of every leaf field of t.
+func walkFields(t reflect.Type, path []string, fn func(path []string, f reflect.StructField)) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name, inline, ok := yamlName(f)
+		if !ok {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if inline {
+			walkFields(ft, path, fn)
+			continue
+		}
+		p := append(path[:len(path):len(path)], name)
+		if ft.Kind() == reflect.Struct && !isScalarStruct(ft) {
+			walkFields(ft, p, fn)
+			continue
+		}
+		fn(p, f)
+	}
+}
+
+func isScalarStruct(t reflect.Type) bool {
+	pt := reflect.PointerTo(t)
+	return pt.Implements(yamlUnmarshalerType) || pt.Implements(textUnmarshalerType)
+}
+
+// yamlName returns the key of f as yaml.v3 does.
+func yamlName(f reflect.StructField) (name string, inline bool, ok bool) {
+	tag := f.Tag.Get("yaml")
+	if tag == "-" {
+		return "", false, false
+	}
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			inline = true
+		}
+	}
+	name = parts[0]
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	return name, inline, true
+}
+
+// valueNode parses the value v of a field of type t. Strings are kept as is,
+// collections are parsed as YAML, e.g. [a, b].
+func valueNode(t reflect.Type, v string) (*yaml.Node, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}, nil
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		if t.Kind() == reflect.Struct && isScalarStruct(t) {
+			break
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(v), &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}, nil
+		}
+		return doc.Content[0], nil
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: v}, nil
+}
+
+func mark(n *yaml.Node, o origin, origins map[*yaml.Node]origin) {
+	origins[n] = o
+	for _, c := range n.Content {
+		mark(c, o, origins)
+	}
+}
+
+// merge merges the mappings of src into dst, any other node of src replaces
+// the one of dst.
+func merge(dst, src *yaml.Node) *yaml.Node {
+	if dst == nil || dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return src
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		k, v := src.Content[i], src.Content[i+1]
+		if j := mappingIndex(dst, k.Value); j >= 0 {
+			dst.Content[j+1] = merge(dst.Content[j+1], v)
+		} else {
+			dst.Content = append(dst.Content, k, v)
+		}
+	}
+	return dst
+}
+
+func mappingIndex(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// setPath sets v at path of the mapping root, creating the missing mappings.
+func setPath(root *yaml.Node, path []string, v *yaml.Node) {
+	n := root
+	for i, key := range path {
+		j := mappingIndex(n, key)
+		if i == len(path)-1 {
+			if j >= 0 {
+				n.Content[j+1] = v
+			} else {
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, v)
+			}
+			return
+		}
+		if j < 0 || n.Content[j+1].Kind != yaml.MappingNode {
+			child := &yaml.Node{Kind: yaml.MappingNode}
+			if j >= 0 {
+				n.Content[j+1] = child
+			} else {
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+			}
+			n = child
+			continue
+		}
+		n = n.Content[j+1]
+	}
+}
+
+var typeErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// checkTypes decodes a layer alone, so its type errors name its file and key.
+func checkTypes(t reflect.Type, n *yaml.Node, o origin) ConfErrors {
+	err := n.Decode(reflect.New(t).Interface())
+	if err == nil {
+		return nil
+	}
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return ConfErrors{{Layer: o.layer, Source: o.source, Err: err}}
+	}
+	var errs ConfErrors
+	for _, msg := range typeErr.Errors {
+		e := &ConfError{Layer: o.layer, Source: o.source, Err: errors.New(msg)}
+		if m := typeErrorLine.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			collection := strings.Contains(m[2], "!!seq") || strings.Contains(m[2], "!!map")
+			e.Path = pathAtLine(n, e.Line, "", collection)
+			e.Err = errors.New(m[2])
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// pathAtLine returns the path of the deepest key of n at line. With
+// collection the error is about a sequence or a mapping, so the flow ones
+// starting at line are not searched.
+func pathAtLine(n *yaml.Node, line int, prefix string, collection bool) string {
+	if collection && n.Line == line && n.Style&yaml.FlowStyle != 0 && prefix != "" {
+		return ""
+	}
+	var found string
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			p := k.Value
+			if prefix != "" {
+				p = prefix + "." + p
+			}
+			if k.Line == line {
+				found = p
+			}
+			if sub := pathAtLine(v, line, p, collection); sub != "" {
+				found = sub
+			}
+		}
+	case yaml.SequenceNode:
+		for i, v := range n.Content {
+			p := fmt.Sprintf("%s[%d]", prefix, i)
+			if v.Line == line {
+				found = p
+			}
+			if sub := pathAtLine(v, line, p, collection); sub != "" {
+				found = sub
+			}
+		}
+	}
+	return found
+}
+
+// findPath returns the node at path, segments like hosts[0] index sequences
+// and mappings.
+func findPath(root *yaml.Node, path []string) *yaml.Node {
+	n := root
+	for _, seg := range path {
+		name, index := seg, ""
+		if i := strings.IndexByte(seg, '['); i >= 0 && strings.HasSuffix(seg, "]") {
+			name, index = seg[:i], seg[i+1:len(seg)-1]
+		}
+		for _, key := range []string{name, index} {
+			if key == "" {
+				continue
+			}
+			if n == nil {
+				return nil
+			}
+			switch n.Kind {
+			case yaml.MappingNode:
+				j := mappingIndex(n, key)
+				if j < 0 {
+					return nil
+				}
+				n = n.Content[j+1]
+			case yaml.SequenceNode:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(n.Content) {
+					return nil
+				}
+				n = n.Content[i]
+			default:
+				return nil
+			}
+		}
+	}
+	return n
+}
+
+const inlineName = ",inline"
+
+// validate checks the validate tags of di and reports every violation with
+// the layer its value came from.
+func validate(di DI, root *yaml.Node, origins map[*yaml.Node]origin) error {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, inline, ok := yamlName(f)
+		if !ok {
+			return ""
+		}
+		if inline {
+			return inlineName
+		}
+		return name
+	})
+	err := v.Struct(di)
+	if err == nil {
+		return nil
+	}
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+	errs := make(ConfErrors, 0, len(fieldErrs))
+	for _, fe := range fieldErrs {
+		var path []string
+		// the first segment is the name of the DI type
+		for _, seg := range strings.Split(fe.Namespace(), ".")[1:] {
+			if seg != inlineName {
+				path = append(path, seg)
+			}
+		}
+		e := &ConfError{Path: strings.Join(path, ".")}
+		rule := fe.Tag()
+		if fe.Param() != "" {
+			rule += "=" + fe.Param()
+		}
+		if n := findPath(root, path); n != nil {
+			o := origins[n]
+			e.Layer, e.Source = o.layer, o.source
+			if o.layer == LayerBase || o.layer == LayerOverlay {
+				e.Line = n.Line
+			}
+			e.Err = fmt.Errorf("value fails the %s rule", rule)
+		} else {
+			e.Err = fmt.Errorf("not set, fails the %s rule", rule)
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
@@ -0,0 +1,104 @@
+package di
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type dbConf struct {
+	URL   string   `yaml:"url" validate:"required,url"`
+	Pool  int      `yaml:"pool" default:"5"`
+	Hosts []string `yaml:"hosts" validate:"dive,hostname"`
+}
+
+type loaderDI struct {
+	CommonServiceDI `yaml:",inline"`
+	Name            string `yaml:"name" validate:"required"`
+	Port            int    `yaml:"port" default:"8080" env:"LOADER_TEST_PORT"`
+	Mode            string `yaml:"mode" default:"release"`
+	DB              dbConf `yaml:"db"`
+}
+
+func (d *loaderDI) IsConfEmpty() error {
+	return nil
+}
+
+func writeConf(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoaderLayers(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "app.yaml")
+	writeConf(t, base, "name: app\nmode: debug\ndb:\n  url: http://base\n  hosts: [db1, db2]\n")
+	writeConf(t, filepath.Join(dir, "prod", "app.yaml"), "db:\n  url: http://prod\n")
+	t.Setenv("APP_DB_POOL", "20")
+	t.Setenv("LOADER_TEST_PORT", "9090")
+
+	var di loaderDI
+	if err := NewLoader(base, WithEnvOverlay("prod"), WithEnvPrefix("APP")).Load(&di); err != nil {
+		t.Fatal(err)
+	}
+	want := dbConf{URL: "http://prod", Pool: 20, Hosts: []string{"db1", "db2"}}
+	if di.Name != "app" || di.Mode != "debug" || di.Port != 9090 || !reflect.DeepEqual(di.DB, want) {
+		t.Errorf("unexpected config %+v", di)
+	}
+
+	// a missing overlay is skipped
+	di = loaderDI{}
+	if err := NewLoader(base, WithEnvOverlay("dev")).Load(&di); err != nil {
+		t.Fatal(err)
+	}
+	if di.DB.URL != "http://base" || di.DB.Pool != 5 {
+		t.Errorf("unexpected config %+v", di)
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "app.yaml")
+	overlay := filepath.Join(dir, "prod", "app.yaml")
+	writeConf(t, base, "db:\n  url: http://base\n  hosts: [db1, db2]\n")
+	writeConf(t, overlay, "db:\n  url: not a url\n  hosts: [db1, \"db 2\"]\n")
+
+	err := NewLoader(base, WithEnvOverlay("prod")).Load(&loaderDI{})
+	var errs ConfErrors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("expected 3 config errors, got %v", err)
+	}
+	if errs[0].Path != "name" || errs[0].Layer != "" {
+		t.Errorf("unexpected error %v", errs[0])
+	}
+	if errs[1].Path != "db.url" || errs[1].Layer != LayerOverlay || errs[1].Source != overlay || errs[1].Line != 2 {
+		t.Errorf("unexpected error %v", errs[1])
+	}
+	if errs[2].Path != "db.hosts[1]" {
+		t.Errorf("unexpected error %v", errs[2])
+	}
+	if msg := "config [db.url] from overlay " + overlay + ":2: value fails the url rule"; !strings.Contains(err.Error(), msg) {
+		t.Errorf("expected %q in %q", msg, err.Error())
+	}
+
+	writeConf(t, overlay, "port: abc\ndb:\n  pool: [1]\n")
+	t.Setenv("LOADER_TEST_PORT", "x")
+	err = NewLoader(base, WithEnvOverlay("prod")).Load(&loaderDI{})
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("expected 3 config errors, got %v", err)
+	}
+	if errs[0].Path != "port" || errs[0].Line != 1 || errs[1].Path != "db.pool" {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if !strings.HasPrefix(errs[2].Error(), "config [port] from env LOADER_TEST_PORT: ") {
+		t.Errorf("unexpected error %v", errs[2])
+	}
+}
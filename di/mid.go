@@ -7,9 +7,10 @@ import (
 	"google.golang.org/grpc"
 )
 
+// GinMiddleHandler puts di in the requests, the current one of a Reloader.
 func GinMiddleHandler(di DI) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		SetDiToGin(c, di)
+		SetDiToGin(c, Current(di))
 		c.Next()
 	}
 }
@@ -19,7 +20,7 @@ func GrpcStreamInterceptor(di DI) grpc.StreamServerInterceptor {
 		if isReflectMethod(info.FullMethod) {
 			return handler(srv, ss)
 		}
-		ctx := SetDiToCtx(ss.Context(), di)
+		ctx := SetDiToCtx(ss.Context(), Current(di))
 
 		return handler(srv, &serverStream{
 			ServerStream: ss,
@@ -30,7 +31,7 @@ func GrpcStreamInterceptor(di DI) grpc.StreamServerInterceptor {
 
 func GrpcUnaryInterceptor(di DI) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		ctx = SetDiToCtx(ctx, di)
+		ctx = SetDiToCtx(ctx, Current(di))
 		return handler(ctx, req)
 	}
 }
@@ -0,0 +1,157 @@
+package di
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/94peter/microservice/configsource"
+)
+
+const defaultCloseDelay = 30 * time.Second
+
+type dynamicDI interface {
+	DI
+	current() DI
+}
+
+// Current returns the DI loaded last by di when it is a Reloader, di itself
+// otherwise.
+func Current(di DI) DI {
+	if d, ok := di.(dynamicDI); ok {
+		return d.current()
+	}
+	return di
+}
+
+// Reloader keeps a DI loaded from config sources and swaps it atomically
+// when they change. It is a DI itself: the middlewares of this package put
+// the current DI in every new request, requests in flight keep theirs.
+type Reloader[T DI] struct {
+	// CloseDelay is how long a replaced DI implementing io.Closer, e.g. by
+	// its ConnPoolConf, stays open for the requests in flight. It is not a
+	// drain: a request still holding the DI afterwards fails on its closed
+	// resources, e.g. with grpc_tool.ErrConnPoolClosed, so keep it above the
+	// longest request.
+	CloseDelay time.Duration
+
+	sources []configsource.ConfigSource
+	load    func(T) error
+	di      atomic.Value
+
+	mu    sync.Mutex
+	hooks []func(old, new T)
+}
+
+// NewReloader reloads di, a pointer to a loaded DI, with load into a new
+// instance of its type whenever one of sources changes.
+func NewReloader[T DI](di T, sources []configsource.ConfigSource, load func(T) error) *Reloader[T] {
+	r := &Reloader[T]{CloseDelay: defaultCloseDelay, sources: sources, load: load}
+	r.di.Store(di)
+	return r
+}
+
+// NewServiceDIReloader reloads di from the config sources of cfg as
+// InitServiceDIByCfg does.
+func NewServiceDIReloader[T ServiceDI](cfg *config, di T) *Reloader[T] {
+	return NewReloader(di, cfg.loader().Sources(), func(di T) error {
+		return InitServiceDIByCfg(cfg, di)
+	})
+}
+
+func (r *Reloader[T]) Get() T {
+	return r.di.Load().(T)
+}
+
+func (r *Reloader[T]) current() DI {
+	return r.Get()
+}
+
+func (r *Reloader[T]) IsConfEmpty() error {
+	return r.Get().IsConfEmpty()
+}
+
+func (r *Reloader[T]) GetService() string {
+	return r.Get().GetService()
+}
+
+// OnReload calls h after every swap, e.g. to rebuild the clients of the
+// new config. old stays in use by the requests in flight.
+func (r *Reloader[T]) OnReload(h func(old, new T)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, h)
+}
+
+// Reload loads the files into a new DI and swaps it when IsConfEmpty passes.
+// On error the previous DI is kept. The replaced DI is closed after
+// CloseDelay when it implements io.Closer.
+func (r *Reloader[T]) Reload() error {
+	return r.reload(nil)
+}
+
+func (r *Reloader[T]) reload(onCloseError func(error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.Get()
+	next := reflect.New(reflect.TypeOf(old).Elem()).Interface().(T)
+	if err := r.load(next); err != nil {
+		return err
+	}
+	if err := next.IsConfEmpty(); err != nil {
+		return err
+	}
+	r.di.Store(next)
+	for _, h := range r.hooks {
+		h(old, next)
+	}
+	if c, ok := any(old).(io.Closer); ok {
+		closeOld := func() {
+			if err := c.Close(); err != nil && onCloseError != nil {
+				onCloseError(fmt.Errorf("close replaced di fail: %w", err))
+			}
+		}
+		if r.CloseDelay <= 0 {
+			closeOld()
+		} else {
+			time.AfterFunc(r.CloseDelay, closeOld)
+		}
+	}
+	return nil
+}
+
+// Watch reloads the DI on source change until ctx is done. Failed reloads,
+// closes of replaced DIs and source watches, e.g. of an overlay in a missing directory, are
+// reported to onError; the other sources are still watched.
+func (r *Reloader[T]) Watch(ctx context.Context, onError func(error)) error {
+	report := func(err error) {
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+	errCh := make(chan error, len(r.sources))
+	for _, src := range r.sources {
+		go func(src configsource.ConfigSource) {
+			err := src.Watch(ctx, func() {
+				report(r.reload(report))
+			})
+			if err != nil {
+				err = fmt.Errorf("watch %s fail: %w", src, err)
+			}
+			report(err)
+			errCh <- err
+		}(src)
+	}
+	var errs []error
+	for range r.sources {
+		if err := <-errCh; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,150 @@
+package di
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type reloadDI struct {
+	Name string `yaml:"name"`
+}
+
+func (d *reloadDI) IsConfEmpty() error {
+	if d.Name == "" {
+		return errors.New("name is empty")
+	}
+	return nil
+}
+
+func (d *reloadDI) GetService() string {
+	return "test"
+}
+
+func TestReloader(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.yaml")
+	writeConf(t, file, "name: v1\n")
+	loader := NewLoader(file)
+	first := &reloadDI{}
+	if err := loader.Load(first); err != nil {
+		t.Fatal(err)
+	}
+	r := NewReloader(first, loader.Sources(), func(di *reloadDI) error {
+		return loader.Load(di)
+	})
+	var reloaded []string
+	r.OnReload(func(old, new *reloadDI) {
+		reloaded = append(reloaded, old.Name+"->"+new.Name)
+	})
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(GinMiddleHandler(r))
+	engine.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, GetDiFromGin[*reloadDI](c).Name)
+	})
+	get := func() string {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Body.String()
+	}
+	if name := get(); name != "v1" {
+		t.Fatalf("expected v1, got %s", name)
+	}
+
+	writeConf(t, file, "name: v2\n")
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := get(); name != "v2" || first.Name != "v1" {
+		t.Errorf("expected v2 for new requests and v1 kept by the old DI, got %s and %s", name, first.Name)
+	}
+
+	// an invalid config keeps the previous DI
+	writeConf(t, file, "name: \"\"\n")
+	if err := r.Reload(); err == nil {
+		t.Error("expected IsConfEmpty error")
+	}
+	writeConf(t, file, "name: [v3]\n")
+	if err := r.Reload(); err == nil {
+		t.Error("expected load error")
+	}
+	if name := get(); name != "v2" {
+		t.Errorf("expected v2 to be kept, got %s", name)
+	}
+	if len(reloaded) != 1 || reloaded[0] != "v1->v2" {
+		t.Errorf("unexpected reloads %v", reloaded)
+	}
+}
+
+func TestReloaderWatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.yaml")
+	writeConf(t, file, "name: v1\n")
+	loader := NewLoader(file)
+	r := NewReloader(&reloadDI{Name: "v1"}, loader.Sources(), func(di *reloadDI) error {
+		return loader.Load(di)
+	})
+	done := make(chan struct{}, 1)
+	r.OnReload(func(_, _ *reloadDI) {
+		done <- struct{}{}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, nil)
+	// let the watcher start
+	time.Sleep(100 * time.Millisecond)
+
+	writeConf(t, file, "name: v2\n")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config not reloaded")
+	}
+	if name := r.Get().Name; name != "v2" {
+		t.Errorf("expected v2, got %s", name)
+	}
+}
+
+type closerDI struct {
+	reloadDI
+	closed chan struct{}
+}
+
+func (d *closerDI) Close() error {
+	close(d.closed)
+	return nil
+}
+
+func TestReloaderClosesReplacedDI(t *testing.T) {
+	first := &closerDI{reloadDI: reloadDI{Name: "v1"}, closed: make(chan struct{})}
+	r := NewReloader(first, nil, func(di *closerDI) error {
+		di.Name = "v2"
+		di.closed = make(chan struct{})
+		return nil
+	})
+	r.CloseDelay = 100 * time.Millisecond
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-first.closed:
+		t.Fatal("expected the replaced DI to stay open for CloseDelay")
+	default:
+	}
+	select {
+	case <-first.closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the replaced DI to be closed")
+	}
+	select {
+	case <-r.Get().closed:
+		t.Error("expected the current DI to stay open")
+	default:
+	}
+}
@@ -0,0 +1,146 @@
+package di
+
+import (
+	"context"
+	"fmt"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Secret is a config value that is redacted when printed, logged or
+// marshalled. Value returns the secret itself.
+type Secret string
+
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// resolveSecrets replaces the placeholders of the scalars of n, a value at
+// path of layer o, and marks them in secrets.
+func (l *Loader) resolveSecrets(ctx context.Context, n *yaml.Node, path string, o origin, secrets map[*yaml.Node]bool) ConfErrors {
+	var errs ConfErrors
+	switch n.Kind {
+	case yaml.ScalarNode:
+		v, resolved, err := l.secrets.Resolve(ctx, n.Value)
+		if err != nil {
+			e := &ConfError{Layer: o.layer, Source: o.source, Path: path, Err: err}
+			if o.layer == LayerBase || o.layer == LayerOverlay {
+				e.Line = n.Line
+			}
+			return ConfErrors{e}
+		}
+		// an escaped placeholder is not a secret
+		n.Value = v
+		if !resolved {
+			return nil
+		}
+		// a plain scalar is typed by its resolved value, a quoted one or a
+		// null like value stays a string
+		if n.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) == 0 && !isNullValue(v) {
+			n.Tag = ""
+		} else {
+			n.Tag = "!!str"
+		}
+		secrets[n] = true
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := n.Content[i].Value
+			if path != "" {
+				p = path + "." + p
+			}
+			errs = append(errs, l.resolveSecrets(ctx, n.Content[i+1], p, o, secrets)...)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			errs = append(errs, l.resolveSecrets(ctx, c, fmt.Sprintf("%s[%d]", path, i), o, secrets)...)
+		}
+	}
+	return errs
+}
+
+func isNullValue(v string) bool {
+	switch v {
+	case "", "~", "null", "Null", "NULL":
+		return true
+	}
+	return false
+}
+
+// secretKeeper is a DI keeping the key paths of its resolved secrets, so
+// Dump redacts them. CommonServiceDI implements it.
+type secretKeeper interface {
+	setSecretPaths(paths [][]string)
+	getSecretPaths() [][]string
+}
+
+func rememberSecrets(di DI, root *yaml.Node, secrets map[*yaml.Node]bool) {
+	k, ok := di.(secretKeeper)
+	if !ok {
+		return
+	}
+	var paths [][]string
+	collectSecrets(root, nil, secrets, &paths)
+	k.setSecretPaths(paths)
+}
+
+func collectSecrets(n *yaml.Node, path []string, secrets map[*yaml.Node]bool, paths *[][]string) {
+	if secrets[n] {
+		*paths = append(*paths, append([]string(nil), path...))
+		return
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			collectSecrets(n.Content[i+1], append(path[:len(path):len(path)], n.Content[i].Value), secrets, paths)
+		}
+	case yaml.SequenceNode:
+		if len(path) == 0 {
+			return
+		}
+		for i, c := range n.Content {
+			p := append([]string(nil), path...)
+			p[len(p)-1] = fmt.Sprintf("%s[%d]", p[len(p)-1], i)
+			collectSecrets(c, p, secrets, paths)
+		}
+	}
+}
+
+// Dump returns di as YAML with the Secret fields redacted, e.g. to log the
+// config. The values resolved from secret placeholders are redacted too when
+// di embeds CommonServiceDI.
+func Dump(di DI) ([]byte, error) {
+	di = Current(di)
+	var root yaml.Node
+	if err := root.Encode(di); err != nil {
+		return nil, err
+	}
+	if k, ok := di.(secretKeeper); ok {
+		for _, p := range k.getSecretPaths() {
+			if n := findPath(&root, p); n != nil && n.Kind == yaml.ScalarNode {
+				n.Value, n.Tag, n.Style = redacted, "!!str", 0
+			}
+		}
+	}
+	return yaml.Marshal(&root)
+}
@@ -0,0 +1,79 @@
+package di
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type secretDI struct {
+	CommonServiceDI `yaml:",inline"`
+
+	DSN      string `yaml:"dsn"`
+	Port     int    `yaml:"port"`
+	Password Secret `yaml:"password"`
+	Name     string `yaml:"name"`
+}
+
+func (d *secretDI) IsConfEmpty() error {
+	return nil
+}
+
+func (d *secretDI) GetService() string {
+	return "test"
+}
+
+func TestLoaderSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "password")
+	writeConf(t, secretFile, "p@ss\n")
+	base := filepath.Join(dir, "app.yaml")
+	writeConf(t, base, "dsn: mongodb://${ENV:SECRET_TEST_USER}@db\nport: ${ENV:SECRET_TEST_PORT}\npassword: ${FILE:"+secretFile+"}\nname: $${ENV:literal}\n")
+	t.Setenv("SECRET_TEST_USER", "admin")
+	t.Setenv("SECRET_TEST_PORT", "27017")
+
+	var di secretDI
+	if err := NewLoader(base).Load(&di); err != nil {
+		t.Fatal(err)
+	}
+	if di.DSN != "mongodb://admin@db" || di.Port != 27017 || di.Password.Value() != "p@ss" || di.Name != "${ENV:literal}" {
+		t.Errorf("unexpected config %#v", di)
+	}
+	if s := fmt.Sprintf("%v %+v", di.Password, di); strings.Contains(s, "p@ss") {
+		t.Errorf("secret printed in %s", s)
+	}
+	b, err := Dump(&di)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := string(b)
+	if strings.Contains(dump, "admin") || strings.Contains(dump, "p@ss") || strings.Contains(dump, "27017") || !strings.Contains(dump, "name: ${ENV:literal}") {
+		t.Errorf("unexpected dump %s", dump)
+	}
+
+	// the secret paths belong to the loaded instance only
+	writeConf(t, base, "dsn: mongodb://admin@db\nport: 27017\n")
+	var plain secretDI
+	if err := NewLoader(base).Load(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = Dump(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if dump := string(b); !strings.Contains(dump, "admin") || !strings.Contains(dump, "27017") {
+		t.Errorf("unexpected dump %s", dump)
+	}
+	if b, err = Dump(&di); err != nil {
+		t.Fatal(err)
+	}
+	if dump := string(b); strings.Contains(dump, "admin") || strings.Contains(dump, "27017") {
+		t.Errorf("unexpected dump %s", dump)
+	}
+
+	writeConf(t, base, "port: 1\ndsn: ${ENV:SECRET_TEST_MISSING}\n")
+	err = NewLoader(base).Load(&secretDI{})
+	if err == nil || !strings.Contains(err.Error(), "config [dsn] from base "+base+":2: resolve ${ENV:SECRET_TEST_MISSING} fail") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package grpc_tool
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// AttemptMetrics counts the attempts of outgoing calls by method and status
+// code, including retried and hedged attempts. It is a prometheus.Collector
+// to be registered by the caller, e.g. with WithPromhttp.
+type AttemptMetrics struct {
+	attempts *prometheus.CounterVec
+}
+
+func NewAttemptMetrics() *AttemptMetrics {
+	return &AttemptMetrics{
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_client_attempts_total",
+			Help: "Total number of gRPC client call attempts.",
+		}, []string{"method", "code"}),
+	}
+}
+
+// WithAttemptMetrics records the attempts of the connection calls in m.
+func WithAttemptMetrics(m *AttemptMetrics) ConnOption {
+	return WithDialOptions(grpc.WithStatsHandler(m))
+}
+
+func (m *AttemptMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.attempts.Describe(ch)
+}
+
+func (m *AttemptMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.attempts.Collect(ch)
+}
+
+type attemptMethodKey struct{}
+
+func (m *AttemptMetrics) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, attemptMethodKey{}, info.FullMethodName)
+}
+
+// HandleRPC is called for every attempt of a call.
+func (m *AttemptMetrics) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	end, ok := s.(*stats.End)
+	if !ok || !end.IsClient() {
+		return
+	}
+	method, _ := ctx.Value(attemptMethodKey{}).(string)
+	m.attempts.WithLabelValues(method, status.Code(end.Error).String()).Inc()
+}
+
+func (m *AttemptMetrics) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (m *AttemptMetrics) HandleConn(context.Context, stats.ConnStats) {}
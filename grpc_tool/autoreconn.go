@@ -0,0 +1,305 @@
+package grpc_tool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultFailureTimeout = 30 * time.Second
+	subscriberBuffer      = 8
+)
+
+// NewAutoReconn returns a connection that is established and kept alive by
+// Run. With a resolver target, a reconnect resolves the addresses again
+// instead of dialing the same host.
+func NewAutoReconn(address string, timeout time.Duration, opts ...ConnOption) *AutoReConn {
+	return &AutoReConn{
+		address:        address,
+		timeout:        timeout,
+		opts:           opts,
+		Backoff:        DefaultBackoff,
+		FailureTimeout: defaultFailureTimeout,
+		Ready:          make(chan bool, 1),
+		Done:           make(chan bool, 1),
+		Reconnect:      make(chan bool, 1),
+	}
+}
+
+// AutoReConn is a Connection to the connection established last. Calls
+// fail with codes.Unavailable while it is not connected.
+type AutoReConn struct {
+	// Deprecated: Connection is read without synchronization while Run
+	// reconnects. Call the methods of AutoReConn instead.
+	Connection
+
+	conn Connection
+
+	address string
+	timeout time.Duration
+	opts    []ConnOption
+
+	// Backoff is the delay between failed connection attempts.
+	Backoff Backoff
+	// FailureTimeout is how long the connection may stay in transient
+	// failure before it is closed and dialed again.
+	FailureTimeout time.Duration
+
+	// Ready receives when a connection is established, Reconnect when a
+	// connection attempt failed or the connection is re-established, and Done
+	// is closed when Run returns. Sends are dropped when nobody listens.
+	Ready     chan bool
+	Done      chan bool
+	Reconnect chan bool
+
+	mu          sync.RWMutex
+	subscribers map[chan connectivity.State]struct{}
+	doneOnce    sync.Once
+}
+
+type GetGrpcFunc func(myGrpc Connection) error
+
+func (my *AutoReConn) Connect(ctx context.Context) (Connection, error) {
+	return NewConnection(ctx, my.address, my.opts...)
+}
+
+func (my *AutoReConn) getConnection() Connection {
+	my.mu.RLock()
+	defer my.mu.RUnlock()
+	return my.conn
+}
+
+func (my *AutoReConn) setConnection(conn Connection) {
+	my.mu.Lock()
+	defer my.mu.Unlock()
+	my.conn = conn
+	my.Connection = conn
+}
+
+func (my *AutoReConn) errNotConnected() error {
+	return status.Errorf(codes.Unavailable, "address [%s] not connected", my.address)
+}
+
+func (my *AutoReConn) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	conn := my.getConnection()
+	if conn == nil {
+		return my.errNotConnected()
+	}
+	return conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+func (my *AutoReConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn := my.getConnection()
+	if conn == nil {
+		return nil, my.errNotConnected()
+	}
+	return conn.NewStream(ctx, desc, method, opts...)
+}
+
+// Close closes the current connection. Run dials again when it is running;
+// cancel its context to stop it.
+func (my *AutoReConn) Close() error {
+	conn := my.getConnection()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+func (my *AutoReConn) WaitUntilReady() bool {
+	conn := my.getConnection()
+	if conn == nil {
+		return false
+	}
+	return conn.WaitUntilReady()
+}
+
+func (my *AutoReConn) IsValid() bool {
+	conn := my.getConnection()
+	if conn == nil {
+		return false
+	}
+	return conn.IsValid()
+}
+
+func (my *AutoReConn) HealthCheck(ctx context.Context) error {
+	conn := my.getConnection()
+	if conn == nil {
+		return fmt.Errorf("address [%s] not connected", my.address)
+	}
+	return checkConnection(conn)
+}
+
+// Subscribe returns a channel receiving the connectivity state changes of the
+// connection, e.g. to pause work while the downstream is unreachable. A slow
+// subscriber misses the oldest states. Call the returned func to unsubscribe.
+func (my *AutoReConn) Subscribe() (<-chan connectivity.State, func()) {
+	ch := make(chan connectivity.State, subscriberBuffer)
+	my.mu.Lock()
+	if my.subscribers == nil {
+		my.subscribers = make(map[chan connectivity.State]struct{})
+	}
+	my.subscribers[ch] = struct{}{}
+	my.mu.Unlock()
+	return ch, func() {
+		my.mu.Lock()
+		delete(my.subscribers, ch)
+		my.mu.Unlock()
+	}
+}
+
+func (my *AutoReConn) publish(state connectivity.State) {
+	my.mu.RLock()
+	defer my.mu.RUnlock()
+	for ch := range my.subscribers {
+		select {
+		case ch <- state:
+			continue
+		default:
+		}
+		// drop the oldest state to keep the latest
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- state:
+		default:
+		}
+	}
+}
+
+// Process connects and calls f until f succeeds, then returns while the
+// connection is kept alive in the background as by Run.
+//
+// Deprecated: Process can not be stopped. Use Run.
+func (my *AutoReConn) Process(f GetGrpcFunc) {
+	ready := make(chan struct{})
+	go my.run(context.Background(), f, func() { close(ready) })
+	<-ready
+}
+
+// Run connects with backoff and calls f with every new connection until f
+// succeeds. It then watches the connection and reconnects when it is shut
+// down or stays in transient failure for FailureTimeout. Run returns when
+// ctx is done, closing the connection.
+func (my *AutoReConn) Run(ctx context.Context, f GetGrpcFunc) error {
+	return my.run(ctx, f, nil)
+}
+
+// run is Run calling onReady once the first connection is set.
+func (my *AutoReConn) run(ctx context.Context, f GetGrpcFunc, onReady func()) error {
+	defer my.doneOnce.Do(func() { close(my.Done) })
+	for {
+		conn, err := my.connect(ctx, f)
+		if err != nil {
+			my.publish(connectivity.Shutdown)
+			return err
+		}
+		my.setConnection(conn)
+		if onReady != nil {
+			onReady()
+			onReady = nil
+		}
+		notify(my.Ready)
+		my.publish(connectivity.Ready)
+
+		lost := my.watch(ctx, conn)
+		my.setConnection(nil)
+		conn.Close()
+		if !lost {
+			my.publish(connectivity.Shutdown)
+			return ctx.Err()
+		}
+		notify(my.Reconnect)
+	}
+}
+
+// connect retries until a connection is established and accepted by f.
+func (my *AutoReConn) connect(ctx context.Context, f GetGrpcFunc) (Connection, error) {
+	for retries := 0; ; retries++ {
+		conn, err := my.dial(ctx)
+		if err == nil {
+			if err = f(conn); err == nil {
+				return conn, nil
+			}
+			conn.Close()
+		}
+		if retries == 0 {
+			notify(my.Reconnect)
+		}
+		timer := time.NewTimer(my.Backoff.Delay(retries))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (my *AutoReConn) dial(ctx context.Context) (Connection, error) {
+	if my.timeout <= 0 {
+		return my.Connect(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, my.timeout)
+	defer cancel()
+	return my.Connect(ctx)
+}
+
+// watch publishes the state changes of conn until ctx is done or the
+// connection is lost, reporting whether it was lost.
+func (my *AutoReConn) watch(ctx context.Context, conn Connection) bool {
+	c, ok := conn.(interface {
+		GetState() connectivity.State
+		WaitForStateChange(context.Context, connectivity.State) bool
+	})
+	if !ok {
+		<-ctx.Done()
+		return false
+	}
+	state := c.GetState()
+	var failedAt time.Time
+	for {
+		waitCtx := ctx
+		var cancel context.CancelFunc = func() {}
+		if !failedAt.IsZero() {
+			waitCtx, cancel = context.WithDeadline(ctx, failedAt.Add(my.FailureTimeout))
+		}
+		changed := c.WaitForStateChange(waitCtx, state)
+		cancel()
+		if ctx.Err() != nil {
+			return false
+		}
+		if !changed {
+			// still failing after FailureTimeout
+			return true
+		}
+		state = c.GetState()
+		my.publish(state)
+		switch state {
+		case connectivity.Shutdown:
+			return true
+		case connectivity.TransientFailure:
+			if failedAt.IsZero() {
+				failedAt = time.Now()
+			}
+		case connectivity.Ready:
+			failedAt = time.Time{}
+		}
+	}
+}
+
+func notify(ch chan bool) {
+	select {
+	case ch <- true:
+	default:
+	}
+}
@@ -0,0 +1,159 @@
+package grpc_tool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/94peter/microservice/health"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestAutoReConnRun(t *testing.T) {
+	// reserve a port and serve on it only after the first attempts failed
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	conn := NewAutoReconn(addr, 100*time.Millisecond)
+	conn.Backoff = Backoff{BaseDelay: 10 * time.Millisecond, Multiplier: 2, MaxDelay: 50 * time.Millisecond}
+	states, unsubscribe := conn.Subscribe()
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- conn.Run(ctx, func(c Connection) error {
+			calls++
+			if calls == 1 {
+				return errors.New("client init fail")
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-conn.Reconnect:
+	case <-time.After(time.Second):
+		t.Fatal("expected a reconnect notification")
+	}
+	cfg := &GrpcConfig{Logger: testLog{}}
+	cfg.SetHealth(health.NewRegistry())
+	cfg.SetRegisterServiceFunc(func(*grpc.Server) {})
+	serv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	select {
+	case <-conn.Ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the connection to become ready")
+	}
+	if calls != 2 {
+		t.Errorf("expected the callback to be retried once, got %d calls", calls)
+	}
+	if state := <-states; state != connectivity.Ready {
+		t.Errorf("expected ready state, got %s", state)
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return after cancel")
+	}
+	if _, ok := <-conn.Done; ok {
+		t.Error("expected Done to be closed")
+	}
+}
+
+func TestAutoReConnInvokeDuringReconnect(t *testing.T) {
+	addr, _ := startTestServer(t)
+	conn := NewAutoReconn(addr, time.Second)
+	conn.Backoff = Backoff{BaseDelay: 10 * time.Millisecond, Multiplier: 2, MaxDelay: 50 * time.Millisecond}
+	client := healthpb.NewHealthClient(conn)
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable before connecting, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go conn.Run(ctx, func(Connection) error { return nil })
+	select {
+	case <-conn.Ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the connection to become ready")
+	}
+
+	stop := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+			// calls racing the closed connection fail, but never panic
+			if code := status.Code(err); code != codes.OK && code != codes.Unavailable && code != codes.Canceled {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		// a closed connection is shut down and dialed again
+		conn.getConnection().Close()
+		select {
+		case <-conn.Ready:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the connection to be re-established")
+		}
+	}
+	close(stop)
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("expected the new connection to serve, got %v", err)
+	}
+}
+
+func TestAutoReConnProcess(t *testing.T) {
+	addr, _ := startTestServer(t)
+	conn := NewAutoReconn(addr, time.Second)
+	var calls int
+	conn.Process(func(c Connection) error {
+		calls++
+		return nil
+	})
+	if calls != 1 || conn.Connection == nil {
+		t.Fatalf("expected a connection after Process, got %d calls", calls)
+	}
+	if _, err := healthpb.NewHealthClient(conn.Connection).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("expected the embedded connection to serve, got %v", err)
+	}
+}
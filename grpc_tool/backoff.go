@@ -0,0 +1,40 @@
+package grpc_tool
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff is an exponential backoff with jitter.
+type Backoff struct {
+	BaseDelay  time.Duration
+	Multiplier float64
+	// Jitter randomizes each delay by up to ±Jitter of it.
+	Jitter   float64
+	MaxDelay time.Duration
+}
+
+// DefaultBackoff matches the connection backoff of gRPC.
+var DefaultBackoff = Backoff{
+	BaseDelay:  time.Second,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+	MaxDelay:   120 * time.Second,
+}
+
+// Delay returns the delay before the given retry, counted from 0.
+func (b Backoff) Delay(retries int) time.Duration {
+	if b.BaseDelay <= 0 {
+		return 0
+	}
+	delay := float64(b.BaseDelay) * math.Pow(math.Max(b.Multiplier, 1), float64(retries))
+	if b.MaxDelay > 0 {
+		delay = math.Min(delay, float64(b.MaxDelay))
+	}
+	delay *= 1 + b.Jitter*(rand.Float64()*2-1)
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
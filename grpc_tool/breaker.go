@@ -0,0 +1,302 @@
+package grpc_tool
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+var breakerStateDesc = prometheus.NewDesc(
+	"grpc_client_circuit_breaker_state",
+	"State of the gRPC client circuit breaker: 0 closed, 1 open, 2 half-open.",
+	[]string{"target", "method"}, nil,
+)
+
+// poolBreakerStateDesc adds the downstream to breakerStateDesc, so a pool
+// and the breakers of another client can be registered together.
+var poolBreakerStateDesc = prometheus.NewDesc(
+	"grpc_client_downstream_circuit_breaker_state",
+	"State of the circuit breaker of a pooled gRPC downstream: 0 closed, 1 open, 2 half-open.",
+	[]string{"downstream", "target", "method"}, nil,
+)
+
+var defaultBreakerFailureCodes = []string{"UNAVAILABLE", "DEADLINE_EXCEEDED", "RESOURCE_EXHAUSTED", "INTERNAL"}
+
+// BreakerConfig configures a circuit breaker. The breaker opens once at
+// least MinRequests calls were made within Window and the ratio of them
+// failing with one of FailureCodes reaches FailureRatio. After CoolDown it
+// lets HalfOpenRequests calls through and closes when they all succeed.
+type BreakerConfig struct {
+	FailureRatio     float64       `yaml:"failureRatio"`
+	MinRequests      int           `yaml:"minRequests"`
+	Window           time.Duration `yaml:"window"`
+	CoolDown         time.Duration `yaml:"coolDown"`
+	HalfOpenRequests int           `yaml:"halfOpenRequests"`
+	FailureCodes     []string      `yaml:"failureCodes"`
+}
+
+// BreakerStatus is the state of the breaker of a target method.
+type BreakerStatus struct {
+	Target string
+	Method string
+	State  BreakerState
+}
+
+// CircuitBreakers keeps a breaker per target and method. It is a
+// prometheus.Collector exporting the breaker states.
+type CircuitBreakers struct {
+	ratio    float64
+	min      int
+	window   time.Duration
+	coolDown time.Duration
+	probes   int
+	failure  map[codes.Code]bool
+	now      func() time.Time
+
+	mu       sync.Mutex
+	breakers map[breakerKey]*breaker
+}
+
+type breakerKey struct {
+	target string
+	method string
+}
+
+// breakerToken is the permit of allow, handed back to done with the result.
+type breakerToken struct {
+	key        breakerKey
+	generation uint64
+}
+
+type breaker struct {
+	state BreakerState
+	// generation changes with every state transition, so that the results
+	// of calls allowed in an earlier state are ignored
+	generation  uint64
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	inFlight    int
+	successes   int
+}
+
+func NewCircuitBreakers(cfg *BreakerConfig) (*CircuitBreakers, error) {
+	if cfg == nil {
+		cfg = &BreakerConfig{}
+	}
+	if cfg.FailureRatio < 0 || cfg.FailureRatio > 1 {
+		return nil, errors.New("breaker failureRatio must be between 0 and 1")
+	}
+	codeNames := cfg.FailureCodes
+	if len(codeNames) == 0 {
+		codeNames = defaultBreakerFailureCodes
+	}
+	names, err := statusCodeNames(codeNames)
+	if err != nil {
+		return nil, err
+	}
+	b := &CircuitBreakers{
+		ratio:    cfg.FailureRatio,
+		min:      cfg.MinRequests,
+		window:   cfg.Window,
+		coolDown: cfg.CoolDown,
+		probes:   cfg.HalfOpenRequests,
+		failure:  make(map[codes.Code]bool, len(names)),
+		now:      time.Now,
+		breakers: make(map[breakerKey]*breaker),
+	}
+	if b.ratio == 0 {
+		b.ratio = 0.5
+	}
+	if b.min <= 0 {
+		b.min = 20
+	}
+	if b.window <= 0 {
+		b.window = 10 * time.Second
+	}
+	if b.coolDown <= 0 {
+		b.coolDown = 30 * time.Second
+	}
+	if b.probes <= 0 {
+		b.probes = 1
+	}
+	for _, name := range names {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(`"` + name + `"`)); err != nil {
+			return nil, err
+		}
+		b.failure[code] = true
+	}
+	return b, nil
+}
+
+// WithCircuitBreaker fails the calls fast with codes.Unavailable while their
+// breaker in b is open. Streams are accounted by the result of their creation.
+func WithCircuitBreaker(b *CircuitBreakers) ConnOption {
+	return WithClientInterceptors(b.Interceptor())
+}
+
+func (b *CircuitBreakers) Interceptor() interceptor.ClientInterceptor {
+	return interceptor.NewSimpleClientInterceptor(
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			token, err := b.allow(breakerKey{target: cc.Target(), method: method})
+			if err != nil {
+				return nil, err
+			}
+			stream, err := streamer(ctx, desc, cc, method, opts...)
+			b.done(token, err)
+			return stream, err
+		},
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			token, err := b.allow(breakerKey{target: cc.Target(), method: method})
+			if err != nil {
+				return err
+			}
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			b.done(token, err)
+			return err
+		},
+	)
+}
+
+// State returns the breaker state of method on target.
+func (b *CircuitBreakers) State(target, method string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br, ok := b.breakers[breakerKey{target: target, method: method}]
+	if !ok {
+		return BreakerClosed
+	}
+	return b.current(br)
+}
+
+// States returns the state of every breaker, sorted by target and method.
+func (b *CircuitBreakers) States() []BreakerStatus {
+	b.mu.Lock()
+	result := make([]BreakerStatus, 0, len(b.breakers))
+	for key, br := range b.breakers {
+		result = append(result, BreakerStatus{Target: key.target, Method: key.method, State: b.current(br)})
+	}
+	b.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Target != result[j].Target {
+			return result[i].Target < result[j].Target
+		}
+		return result[i].Method < result[j].Method
+	})
+	return result
+}
+
+func (b *CircuitBreakers) Describe(ch chan<- *prometheus.Desc) {
+	ch <- breakerStateDesc
+}
+
+func (b *CircuitBreakers) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range b.States() {
+		ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, float64(s.State), s.Target, s.Method)
+	}
+}
+
+// current moves an open breaker to half-open once its cool-down is over.
+func (b *CircuitBreakers) current(br *breaker) BreakerState {
+	if br.state == BreakerOpen && b.now().Sub(br.openedAt) >= b.coolDown {
+		b.transition(br, BreakerHalfOpen)
+	}
+	return br.state
+}
+
+// transition moves br to state with fresh counters.
+func (b *CircuitBreakers) transition(br *breaker, state BreakerState) {
+	now := b.now()
+	*br = breaker{state: state, generation: br.generation + 1, windowStart: now}
+	if state == BreakerOpen {
+		br.openedAt = now
+	}
+}
+
+func (b *CircuitBreakers) allow(key breakerKey) (breakerToken, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br, ok := b.breakers[key]
+	if !ok {
+		br = &breaker{windowStart: b.now()}
+		b.breakers[key] = br
+	}
+	switch b.current(br) {
+	case BreakerOpen:
+		return breakerToken{}, status.Errorf(codes.Unavailable, "circuit breaker of %s on %s is open", key.method, key.target)
+	case BreakerHalfOpen:
+		if br.inFlight >= b.probes {
+			return breakerToken{}, status.Errorf(codes.Unavailable, "circuit breaker of %s on %s is half-open", key.method, key.target)
+		}
+		br.inFlight++
+	}
+	return breakerToken{key: key, generation: br.generation}, nil
+}
+
+// done accounts the result of a call allowed by token. Results of calls
+// allowed before the last state transition are ignored.
+func (b *CircuitBreakers) done(token breakerToken, err error) {
+	failed := err != nil && b.failure[status.Code(err)]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := b.breakers[token.key]
+	if br.generation != token.generation {
+		return
+	}
+	now := b.now()
+	switch br.state {
+	case BreakerHalfOpen:
+		br.inFlight--
+		if failed {
+			b.transition(br, BreakerOpen)
+			return
+		}
+		br.successes++
+		if br.successes >= b.probes {
+			b.transition(br, BreakerClosed)
+		}
+	case BreakerClosed:
+		if now.Sub(br.windowStart) >= b.window {
+			br.windowStart = now
+			br.requests = 0
+			br.failures = 0
+		}
+		br.requests++
+		if failed {
+			br.failures++
+		}
+		if br.requests >= b.min && float64(br.failures)/float64(br.requests) >= b.ratio {
+			b.transition(br, BreakerOpen)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package grpc_tool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	b, err := NewCircuitBreakers(&BreakerConfig{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		CoolDown:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	key := breakerKey{target: "billing", method: "/billing.Billing/Charge"}
+	call := func(err error) error {
+		token, allowErr := b.allow(key)
+		if allowErr != nil {
+			return allowErr
+		}
+		b.done(token, err)
+		return nil
+	}
+
+	unavailable := status.Error(codes.Unavailable, "down")
+	for _, err := range []error{nil, status.Error(codes.NotFound, "not counted"), unavailable, unavailable} {
+		if err := call(err); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := b.State(key.target, key.method); s != BreakerOpen {
+		t.Fatalf("expected open breaker, got %s", s)
+	}
+	if err := call(nil); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected fast failure, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	probe, err := b.allow(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.allow(key); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected a single half-open probe, got %v", err)
+	}
+	b.done(probe, nil)
+	if s := b.State(key.target, key.method); s != BreakerClosed {
+		t.Errorf("expected closed breaker, got %s", s)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(b)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || len(families[0].GetMetric()) != 1 {
+		t.Errorf("unexpected metrics %v", families)
+	}
+}
+
+func TestCircuitBreakersLateResult(t *testing.T) {
+	b, err := NewCircuitBreakers(&BreakerConfig{
+		FailureRatio: 0.5,
+		MinRequests:  2,
+		CoolDown:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	key := breakerKey{target: "billing", method: "/billing.Billing/Charge"}
+	unavailable := status.Error(codes.Unavailable, "down")
+
+	// a slow call allowed while closed
+	slow, err := b.allow(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		token, err := b.allow(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.done(token, unavailable)
+	}
+	now = now.Add(time.Minute)
+	if s := b.State(key.target, key.method); s != BreakerHalfOpen {
+		t.Fatalf("expected half-open breaker, got %s", s)
+	}
+
+	// its success neither frees the probe slot nor closes the breaker
+	b.done(slow, nil)
+	if s := b.State(key.target, key.method); s != BreakerHalfOpen {
+		t.Errorf("expected the late result to be ignored, got %s", s)
+	}
+	probe, err := b.allow(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.allow(key); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected a single half-open probe, got %v", err)
+	}
+
+	// nor does a late failure re-open the closed breaker
+	b.done(probe, nil)
+	b.done(slow, unavailable)
+	if s := b.State(key.target, key.method); s != BreakerClosed {
+		t.Errorf("expected closed breaker, got %s", s)
+	}
+}
@@ -2,11 +2,17 @@ package grpc_tool
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/health"
+	"github.com/94peter/microservice/requestid"
+	"github.com/94peter/microservice/tlstool"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -17,24 +23,63 @@ type Connection interface {
 	WaitUntilReady() bool
 }
 
-func NewConnection(ctx context.Context, address string) (Connection, error) {
-	conn, err := grpc.DialContext(ctx, address,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+// NewConnection dials address and blocks until the connection is ready.
+// Besides host:port, address may be "static:///host1:port,host2:port",
+// "dnssrv:///_grpc._tcp.name" or the target passed to a WithResolver Resolver;
+// the resolved addresses are balanced as selected by WithBalancer. Calls are
+// traced and carry the W3C trace context and request id of their ctx.
+func NewConnection(ctx context.Context, address string, opts ...ConnOption) (Connection, error) {
+	o := newConnOptions(opts)
+	creds := insecure.NewCredentials()
+	var loaded *tlstool.Loaded
+	if o.tls != nil {
+		var err error
+		loaded, err = o.tls.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("address [%s] error: %s", address, err.Error())
+		}
+		creds = credentials.NewTLS(loaded.Config)
+	}
+	target, targetOpts, err := o.target(address)
+	if err != nil {
+		return nil, fmt.Errorf("address [%s] error: %s", address, err.Error())
+	}
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
 		grpc.WithBlock(),
-	)
+	}
+	for _, i := range []interceptor.ClientInterceptor{interceptor.NewClientTracingInterceptor(), requestid.ClientInterceptor()} {
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(i.UnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(i.StreamClientInterceptor()),
+		)
+	}
+	dialOpts = append(dialOpts, targetOpts...)
+	dialOpts = append(dialOpts, o.dialOpts...)
+	conn, err := grpc.DialContext(ctx, target, dialOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("address [%s] error: %s", address, err.Error())
 	}
-	return &myGrpcImpl{
+	impl := &myGrpcImpl{
 		ClientConn: conn,
-	}, nil
+	}
+	if loaded != nil {
+		var watchCtx context.Context
+		watchCtx, impl.cancel = context.WithCancel(context.Background())
+		go loaded.Watch(watchCtx, nil)
+	}
+	return impl, nil
 }
 
 type myGrpcImpl struct {
 	*grpc.ClientConn
+	cancel context.CancelFunc
 }
 
 func (my *myGrpcImpl) Close() error {
+	if my.cancel != nil {
+		my.cancel()
+	}
 	return my.ClientConn.Close()
 }
 
@@ -58,65 +103,22 @@ func (my *myGrpcImpl) WaitUntilReady() bool {
 	return my.WaitForStateChange(ctx, connectivity.Ready)
 }
 
-func NewAutoReconn(address string, timeout time.Duration) *AutoReConn {
-	return &AutoReConn{
-		address:   address,
-		timeout:   timeout,
-		Ready:     make(chan bool),
-		Done:      make(chan bool),
-		Reconnect: make(chan bool),
+// HealthChecker reports conn as unhealthy unless it is ready or idle.
+func HealthChecker(conn Connection) health.Checker {
+	return func(ctx context.Context) error {
+		return checkConnection(conn)
 	}
 }
 
-type AutoReConn struct {
-	Connection
-
-	address string
-	timeout time.Duration
-
-	Ready     chan bool
-	Done      chan bool
-	Reconnect chan bool
-}
-
-type GetGrpcFunc func(myGrpc Connection) error
-
-func (my *AutoReConn) Connect(ctx context.Context) (Connection, error) {
-	return NewConnection(ctx, my.address)
-}
-
-func (my *AutoReConn) IsValid() bool {
-	if my.Connection == nil {
-		return false
-	}
-	return my.Connection.IsValid()
-}
-
-func (my *AutoReConn) Process(f GetGrpcFunc) {
-	var err error
-	basicCtx := context.Background()
-	var ctx context.Context
-	var cancel context.CancelFunc
-	for {
-		if cancel != nil {
-			cancel()
-		}
-		if my.timeout > 0 {
-			ctx, cancel = context.WithTimeout(basicCtx, my.timeout)
-		} else {
-			ctx = basicCtx
-		}
-
-		my.Connection, err = my.Connect(ctx)
-		if err != nil {
-			continue
-		}
-		if err = f(my.Connection); err != nil {
-			continue
-		}
-		break
+func checkConnection(conn Connection) error {
+	c, ok := conn.(interface{ GetState() connectivity.State })
+	if !ok {
+		return errors.New("connection not established")
 	}
-	if cancel != nil {
-		cancel()
+	switch state := c.GetState(); state {
+	case connectivity.Ready, connectivity.Idle:
+		return nil
+	default:
+		return fmt.Errorf("connection state is %s", state)
 	}
 }
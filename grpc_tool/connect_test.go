@@ -0,0 +1,231 @@
+package grpc_tool
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/health"
+	"go.opentelemetry.io/otel"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+type testLog struct{}
+
+func (testLog) Infof(format string, a ...any)  {}
+func (testLog) Fatalf(format string, a ...any) {}
+
+// startTestServer serves the health service on a random port and counts the
+// unary calls it receives.
+func startTestServer(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+	var count atomic.Int64
+	cfg := &GrpcConfig{Logger: testLog{}}
+	cfg.SetHealth(health.NewRegistry())
+	cfg.SetRegisterServiceFunc(func(*grpc.Server) {})
+	cfg.SetInterceptors(interceptor.NewSimpleInterceptor(
+		func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			return handler(srv, ss)
+		},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			count.Add(1)
+			return handler(ctx, req)
+		},
+	))
+	serv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serv.Serve(lis)
+	t.Cleanup(serv.Stop)
+	return lis.Addr().String(), &count
+}
+
+func TestNewConnectionRoundRobin(t *testing.T) {
+	addr1, count1 := startTestServer(t)
+	addr2, count2 := startTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewConnection(ctx, "billing",
+		WithResolver(StaticResolver(addr1, addr2), 0),
+		WithBalancer(BalancerRoundRobin),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	// the second address may still be connecting when the dial returns
+	for i := 0; i < 100 && (count1.Load() == 0 || count2.Load() == 0); i++ {
+		if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count1.Load() == 0 || count2.Load() == 0 {
+		t.Errorf("expected calls on both servers, got %d and %d", count1.Load(), count2.Load())
+	}
+}
+
+func TestNewConnectionStaticScheme(t *testing.T) {
+	addr, count := startTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewConnection(ctx, StaticScheme+":///"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if count.Load() == 0 {
+		t.Error("expected a call on the server")
+	}
+	// the schemes are resolved per connection only
+	for _, scheme := range []string{StaticScheme, DNSSRVScheme} {
+		if resolver.Get(scheme) != nil {
+			t.Errorf("expected scheme %s not to be registered globally", scheme)
+		}
+	}
+}
+
+func TestNewConnectionUnknownBalancer(t *testing.T) {
+	_, err := NewConnection(context.Background(), "static:///127.0.0.1:1", WithBalancer("random"))
+	if err == nil {
+		t.Error("expected error for unknown balancer")
+	}
+}
+
+func TestNewConnectionClientInterceptors(t *testing.T) {
+	addr, count := startTestServer(t)
+
+	var attempts atomic.Int64
+	var gotMD metadata.MD
+	failOnce := interceptor.NewSimpleClientInterceptor(nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			gotMD, _ = metadata.FromOutgoingContext(ctx)
+			if attempts.Add(1) == 1 {
+				return status.Error(codes.Unavailable, "try again")
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewConnection(ctx, addr, WithClientInterceptors(
+		interceptor.NewMetadataPropagationInterceptor("x-request-id"),
+		interceptor.NewRetryInterceptor(3, func(int) time.Duration { return time.Millisecond }),
+		failOnce,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	callCtx := metadata.NewIncomingContext(ctx, metadata.Pairs("x-request-id", "abc", "other", "x"))
+	if _, err := healthpb.NewHealthClient(conn).Check(callCtx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Load() != 2 || count.Load() != 1 {
+		t.Errorf("expected 2 attempts and 1 server call, got %d and %d", attempts.Load(), count.Load())
+	}
+	if v := gotMD.Get("x-request-id"); len(v) != 1 || v[0] != "abc" {
+		t.Errorf("x-request-id not propagated: %v", gotMD)
+	}
+	if v := gotMD.Get("other"); len(v) != 0 {
+		t.Errorf("unexpected key propagated: %v", gotMD)
+	}
+}
+
+func TestNewConnectionTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	addr, _ := startTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewConnection(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var client, server sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		switch s.SpanKind() {
+		case trace.SpanKindClient:
+			client = s
+		case trace.SpanKindServer:
+			server = s
+		}
+	}
+	if client == nil || server == nil {
+		t.Fatalf("expected client and server spans, got %v", recorder.Ended())
+	}
+	if server.Parent().SpanID() != client.SpanContext().SpanID() || server.SpanContext().TraceID() != client.SpanContext().TraceID() {
+		t.Error("server span does not continue the client trace")
+	}
+}
+
+func TestNewConnectionTracingCancelledStream(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	addr, _ := startTestServer(t)
+	conn, err := NewConnection(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	// the stream is abandoned without reading to EOF
+	cancel()
+
+	for i := 0; i < 100; i++ {
+		for _, s := range recorder.Ended() {
+			if s.SpanKind() == trace.SpanKindClient {
+				if s.Status().Code != otelCodes.Error {
+					t.Errorf("expected an error status, got %v", s.Status())
+				}
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected the client span to end with the stream context")
+}
@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// setField sets the field at the dotted path of msg from its string form.
+// Repeated fields get one element per value.
+func setField(msg protoreflect.Message, path string, values []string) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := findField(msg.Descriptor(), name)
+		if fd == nil {
+			return fmt.Errorf("field [%s] not found in %s", path, msg.Descriptor().FullName())
+		}
+		if i < len(names)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("field [%s] is not a message", path)
+			}
+			msg = msg.Mutable(fd).Message()
+			continue
+		}
+		if fd.IsMap() || (fd.Message() != nil) {
+			return fmt.Errorf("field [%s] must be a scalar", path)
+		}
+		if fd.IsList() {
+			list := msg.Mutable(fd).List()
+			for _, v := range values {
+				val, err := parseScalar(fd, v)
+				if err != nil {
+					return fmt.Errorf("field [%s]: %w", path, err)
+				}
+				list.Append(val)
+			}
+			return nil
+		}
+		if len(values) == 0 {
+			return nil
+		}
+		val, err := parseScalar(fd, values[len(values)-1])
+		if err != nil {
+			return fmt.Errorf("field [%s]: %w", path, err)
+		}
+		msg.Set(fd, val)
+	}
+	return nil
+}
+
+// findField looks a field up by its proto name or its JSON name.
+func findField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		v, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid enum value [%s]", s)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported kind %s", fd.Kind())
+	}
+}
@@ -0,0 +1,308 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	apiErr "github.com/94peter/microservice/apitool/err"
+	"github.com/94peter/microservice/grpc_tool"
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/requestid"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	bufSize = 1024 * 1024
+
+	metadataHeaderPrefix = "Grpc-Metadata-"
+)
+
+var (
+	unmarshalOpts = protojson.UnmarshalOptions{DiscardUnknown: true}
+	marshalOpts   = protojson.MarshalOptions{EmitUnpopulated: true}
+)
+
+// Gateway transcodes JSON/HTTP requests to the unary methods registered on a
+// grpc_tool.Server. The server is served in-process over an in-memory
+// listener, so its interceptors apply to transcoded calls as well.
+type Gateway struct {
+	serv   *grpc_tool.Server
+	lis    *bufconn.Listener
+	conn   *grpc.ClientConn
+	routes []*route
+}
+
+type route struct {
+	method       string
+	path         string
+	fullMethod   string
+	input        protoreflect.MessageType
+	output       protoreflect.MessageType
+	vars         []*pathVar
+	body         string
+	responseBody string
+}
+
+func New(serv *grpc_tool.Server) (_ *Gateway, err error) {
+	g := &Gateway{
+		serv: serv,
+		lis:  bufconn.Listen(bufSize),
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if g.conn != nil {
+			g.conn.Close()
+		}
+		g.lis.Close()
+	}()
+	g.conn, err = grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return g.lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(
+			interceptor.NewClientTracingInterceptor().UnaryClientInterceptor(),
+			requestid.ClientInterceptor().UnaryClientInterceptor(),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+	for name, info := range serv.GetServiceInfo() {
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			// services without a registered descriptor can not be transcoded
+			continue
+		}
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			continue
+		}
+		for _, m := range info.Methods {
+			if m.IsClientStream || m.IsServerStream {
+				continue
+			}
+			md := sd.Methods().ByName(protoreflect.Name(m.Name))
+			if md == nil {
+				continue
+			}
+			routes, err := newRoutes(sd, md)
+			if err != nil {
+				return nil, err
+			}
+			g.routes = append(g.routes, routes...)
+		}
+	}
+	go serv.Serve(g.lis)
+	return g, nil
+}
+
+func newRoutes(sd protoreflect.ServiceDescriptor, md protoreflect.MethodDescriptor) ([]*route, error) {
+	fullMethod := fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())
+	input, output := messageType(md.Input()), messageType(md.Output())
+	routes := []*route{{
+		method:     http.MethodPost,
+		path:       fullMethod,
+		fullMethod: fullMethod,
+		input:      input,
+		output:     output,
+		body:       "*",
+	}}
+	rule, ok := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return routes, nil
+	}
+	for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+		method, tpl := httpPattern(r)
+		if tpl == "" {
+			continue
+		}
+		path, vars, err := parseTemplate(tpl)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fullMethod, err)
+		}
+		routes = append(routes, &route{
+			method:       method,
+			path:         path,
+			fullMethod:   fullMethod,
+			input:        input,
+			output:       output,
+			vars:         vars,
+			body:         r.GetBody(),
+			responseBody: r.GetResponseBody(),
+		})
+	}
+	return routes, nil
+}
+
+func httpPattern(r *annotations.HttpRule) (string, string) {
+	switch p := r.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		return strings.ToUpper(p.Custom.GetKind()), p.Custom.GetPath()
+	}
+	return "", ""
+}
+
+func messageType(md protoreflect.MessageDescriptor) protoreflect.MessageType {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName()); err == nil {
+		return mt
+	}
+	return dynamicpb.NewMessageType(md)
+}
+
+// Register mounts the transcoding routes on r. Errors are converted to
+// apitool/err.ApiError with the HTTP status of their gRPC code.
+func (g *Gateway) Register(r gin.IRoutes, errHandler apiErr.GinErrorHandler) (err error) {
+	defer func() {
+		// gin panics on conflicting routes
+		if r := recover(); r != nil {
+			err = fmt.Errorf("register gateway route fail: %v", r)
+		}
+	}()
+	for _, rt := range g.routes {
+		r.Handle(rt.method, rt.path, g.handler(rt, errHandler))
+	}
+	return nil
+}
+
+// Close stops the in-process server and closes the gateway connection.
+func (g *Gateway) Close() error {
+	err := g.conn.Close()
+	g.serv.GracefulShutdown()
+	return err
+}
+
+func (g *Gateway) handler(rt *route, errHandler apiErr.GinErrorHandler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, err := rt.newRequest(c)
+		if err != nil {
+			errHandler(c, apiErr.PkgError(http.StatusBadRequest, err))
+			return
+		}
+		resp := rt.output.New().Interface()
+		ctx := metadata.NewOutgoingContext(c.Request.Context(), incomingMetadata(c.Request))
+		if err := g.conn.Invoke(ctx, rt.fullMethod, req, resp); err != nil {
+			errHandler(c, apiErr.FromGrpcError(err))
+			return
+		}
+		b, err := rt.marshalResponse(resp)
+		if err != nil {
+			errHandler(c, apiErr.PkgError(http.StatusInternalServerError, err))
+			return
+		}
+		c.Data(http.StatusOK, "application/json", b)
+	}
+}
+
+func (rt *route) newRequest(c *gin.Context) (proto.Message, error) {
+	msg := rt.input.New()
+	bound := make(map[string]bool)
+	switch rt.body {
+	case "":
+	case "*":
+		if err := unmarshalBody(c.Request.Body, msg.Interface()); err != nil {
+			return nil, err
+		}
+	default:
+		b, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 0 {
+			// wrap the body so any field type can be decoded
+			wrapped, _ := json.Marshal(map[string]json.RawMessage{rt.body: b})
+			if err := unmarshalOpts.Unmarshal(wrapped, msg.Interface()); err != nil {
+				return nil, err
+			}
+		}
+		bound[rt.body] = true
+	}
+	for _, v := range rt.vars {
+		if err := setField(msg, v.field, []string{v.value(c)}); err != nil {
+			return nil, err
+		}
+		bound[v.field] = true
+	}
+	if rt.body == "*" {
+		return msg.Interface(), nil
+	}
+	for key, values := range c.Request.URL.Query() {
+		if bound[key] || bound[strings.Split(key, ".")[0]] {
+			continue
+		}
+		if err := setField(msg, key, values); err != nil {
+			// unknown query params are ignored
+			continue
+		}
+	}
+	return msg.Interface(), nil
+}
+
+func unmarshalBody(r io.Reader, msg proto.Message) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return unmarshalOpts.Unmarshal(b, msg)
+}
+
+func (rt *route) marshalResponse(resp proto.Message) ([]byte, error) {
+	b, err := marshalOpts.Marshal(resp)
+	if err != nil || rt.responseBody == "" {
+		return b, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	fd := findField(resp.ProtoReflect().Descriptor(), rt.responseBody)
+	if fd == nil {
+		return nil, fmt.Errorf("response field [%s] not found", rt.responseBody)
+	}
+	return fields[fd.JSONName()], nil
+}
+
+// incomingMetadata forwards the Authorization header and the headers
+// prefixed with Grpc-Metadata- as gRPC metadata.
+func incomingMetadata(req *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range req.Header {
+		switch {
+		case key == "Authorization":
+			md.Append("authorization", values...)
+		case strings.HasPrefix(key, metadataHeaderPrefix):
+			md.Append(strings.TrimPrefix(key, metadataHeaderPrefix), values...)
+		}
+	}
+	return md
+}
@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/94peter/microservice/grpc_tool"
+	"github.com/94peter/microservice/health"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		tpl    string
+		path   string
+		fields []string
+		err    bool
+	}{
+		{tpl: "/v1/books/{id}", path: "/v1/books/:s2", fields: []string{"id"}},
+		{tpl: "/v1/{name=shelves/*}/books/{book.id}", path: "/v1/shelves/:s2/books/:s4", fields: []string{"name", "book.id"}},
+		{tpl: "/v1/files/{path=**}", path: "/v1/files/*s2", fields: []string{"path"}},
+		{tpl: "/v1/{name=**}/books", err: true},
+		{tpl: "/v1/books/{id}:cancel", err: true},
+		{tpl: "v1/books", err: true},
+	}
+	for _, tt := range tests {
+		path, vars, err := parseTemplate(tt.tpl)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.tpl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.tpl, err)
+			continue
+		}
+		if path != tt.path {
+			t.Errorf("%s: expected path %s, got %s", tt.tpl, tt.path, path)
+		}
+		var fields []string
+		for _, v := range vars {
+			fields = append(fields, v.field)
+		}
+		if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+			t.Errorf("%s: expected fields %v, got %v", tt.tpl, tt.fields, fields)
+		}
+	}
+}
+
+type testLog struct{}
+
+func (testLog) Infof(format string, a ...any)  {}
+func (testLog) Fatalf(format string, a ...any) {}
+
+func TestGatewayFallbackRoute(t *testing.T) {
+	cfg := &grpc_tool.GrpcConfig{Logger: testLog{}}
+	cfg.SetHealth(health.NewRegistry())
+	cfg.SetRegisterServiceFunc(func(*grpc.Server) {})
+	serv, err := grpc_tool.NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(serv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	var handledErr error
+	if err := g.Register(engine, func(c *gin.Context, err error) { handledErr = err }); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/grpc.health.v1.Health/Check", strings.NewReader(`{}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"SERVING"`) {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/grpc.health.v1.Health/Check", strings.NewReader(`{"service":"unknown"}`)))
+	if handledErr == nil || !strings.Contains(handledErr.Error(), "not found") {
+		t.Errorf("expected not found error, got %v", handledErr)
+	}
+}
@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pathVar binds a field to one or more gin params. The field value is the
+// param values joined with the literal segments of the variable pattern.
+type pathVar struct {
+	field string
+	parts []varPart
+}
+
+type varPart struct {
+	literal string
+	param   string
+	// wildcard params (**) hold the rest of the path
+	wildcard bool
+}
+
+func (v *pathVar) value(c *gin.Context) string {
+	values := make([]string, 0, len(v.parts))
+	for _, p := range v.parts {
+		if p.param == "" {
+			values = append(values, p.literal)
+			continue
+		}
+		val := c.Param(p.param)
+		if p.wildcard {
+			val = strings.TrimPrefix(val, "/")
+		}
+		values = append(values, val)
+	}
+	return strings.Join(values, "/")
+}
+
+// parseTemplate converts a google.api.http path template, e.g.
+// "/v1/{name=shelves/*}/books/{book.id}", to a gin path and its variables.
+// Params are named after their segment index so that templates sharing a
+// prefix produce the same gin wildcards.
+func parseTemplate(tpl string) (string, []*pathVar, error) {
+	if !strings.HasPrefix(tpl, "/") {
+		return "", nil, fmt.Errorf("path template [%s] must start with /", tpl)
+	}
+	if i := strings.LastIndex(tpl, ":"); i > strings.LastIndex(tpl, "}") && i > strings.LastIndex(tpl, "/") {
+		return "", nil, fmt.Errorf("path template [%s] verb is not supported", tpl)
+	}
+	var (
+		segments []string
+		vars     []*pathVar
+	)
+	rest := tpl[1:]
+	for rest != "" {
+		if rest[0] != '{' {
+			seg, remain, _ := strings.Cut(rest, "/")
+			if strings.ContainsAny(seg, "{}") || seg == "" {
+				return "", nil, fmt.Errorf("path template [%s] is invalid", tpl)
+			}
+			switch seg {
+			case "*":
+				segments = append(segments, ":s"+strconv.Itoa(len(segments)))
+			case "**":
+				segments = append(segments, "*s"+strconv.Itoa(len(segments)))
+			default:
+				segments = append(segments, seg)
+			}
+			rest = remain
+			continue
+		}
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			return "", nil, fmt.Errorf("path template [%s] is invalid", tpl)
+		}
+		field, pattern, ok := strings.Cut(rest[1:end], "=")
+		if !ok {
+			pattern = "*"
+		}
+		v := &pathVar{field: field}
+		for _, seg := range strings.Split(pattern, "/") {
+			switch seg {
+			case "*":
+				name := "s" + strconv.Itoa(len(segments))
+				segments = append(segments, ":"+name)
+				v.parts = append(v.parts, varPart{param: name})
+			case "**":
+				name := "s" + strconv.Itoa(len(segments))
+				segments = append(segments, "*"+name)
+				v.parts = append(v.parts, varPart{param: name, wildcard: true})
+			default:
+				segments = append(segments, seg)
+				v.parts = append(v.parts, varPart{literal: seg})
+			}
+		}
+		vars = append(vars, v)
+		rest = rest[end+1:]
+		if rest != "" {
+			if rest[0] != '/' {
+				return "", nil, fmt.Errorf("path template [%s] is invalid", tpl)
+			}
+			rest = rest[1:]
+		}
+	}
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, "*") && i != len(segments)-1 {
+			return "", nil, fmt.Errorf("path template [%s]: ** must be the last segment", tpl)
+		}
+	}
+	return "/" + strings.Join(segments, "/"), vars, nil
+}
@@ -1,19 +1,39 @@
 package grpc_tool
 
 import (
+	"context"
+	"time"
+
 	"github.com/94peter/microservice/cfg"
 	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/health"
+	"github.com/94peter/microservice/tlstool"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"google.golang.org/grpc"
 )
 
+const defaultGracefulTimeout = 10 * time.Second
+
 type GrpcConfig struct {
-	Port           int  `env:"GRPC_PORT"`
-	ReflectService bool `env:"GRPC_REFLECT"`
+	Port            int           `env:"GRPC_PORT"`
+	ReflectService  bool          `env:"GRPC_REFLECT"`
+	GracefulTimeout time.Duration `env:"GRPC_GRACEFUL_TIMEOUT,opt"`
+
+	TLSCert string `env:"GRPC_TLS_CERT,opt"`
+	TLSKey  string `env:"GRPC_TLS_KEY,opt"`
+	// TLSClientCA enables client certificate verification.
+	TLSClientCA string `env:"GRPC_TLS_CLIENT_CA,opt"`
+	// TLSRequireClientCert rejects clients without a verified certificate.
+	TLSRequireClientCert bool `env:"GRPC_TLS_REQUIRE_CLIENT_CERT,opt"`
 
 	Logger              Log
 	registerServiceFunc func(grpcServer *grpc.Server)
 	interceptors        []interceptor.Interceptor
+	health              *health.Registry
+	panicHandlers       []interceptor.PanicHandler
+	metricsService      string
+	metricsReg          prometheus.Registerer
 }
 
 func (c *GrpcConfig) SetRegisterServiceFunc(f func(grpcServer *grpc.Server)) {
@@ -24,6 +44,83 @@ func (c *GrpcConfig) SetInterceptors(i ...interceptor.Interceptor) {
 	c.interceptors = i
 }
 
+// OnPanic adds h to the handlers called when a service handler panics. The
+// panic is logged with Logger and the call fails with codes.Internal.
+func (c *GrpcConfig) OnPanic(h interceptor.PanicHandler) {
+	c.panicHandlers = append(c.panicHandlers, h)
+}
+
+func (c *GrpcConfig) onPanic(ctx context.Context, method string, recovered any, stack []byte) {
+	if l, ok := c.Logger.(interface{ Errorf(format string, a ...any) }); ok {
+		l.Errorf("grpc method [%s] panic: %v\n%s", method, recovered, stack)
+	} else if c.Logger != nil {
+		c.Logger.Infof("grpc method [%s] panic: %v\n%s", method, recovered, stack)
+	}
+	for _, h := range c.panicHandlers {
+		h(ctx, method, recovered, stack)
+	}
+}
+
+// SetMetrics records the call metrics and the panics_total counter of the
+// server RunGrpcServ starts on reg, labelled with service.
+func (c *GrpcConfig) SetMetrics(service string, reg prometheus.Registerer) {
+	c.metricsService, c.metricsReg = service, reg
+}
+
+func (c *GrpcConfig) metricsOptions() (*serverOptions, error) {
+	o := &serverOptions{}
+	if c.metricsReg == nil {
+		return o, nil
+	}
+	metrics, err := interceptor.NewMetricsInterceptor(c.metricsService, c.metricsReg)
+	if err != nil {
+		return nil, err
+	}
+	onPanic, err := newPanicMetric(c.metricsService, c.metricsReg)
+	if err != nil {
+		return nil, err
+	}
+	o.interceptors = append(o.interceptors, metrics)
+	o.panicHandlers = append(o.panicHandlers, onPanic)
+	return o, nil
+}
+
+// SetHealth serves reg as grpc.health.v1.Health.
+func (c *GrpcConfig) SetHealth(reg *health.Registry) {
+	c.health = reg
+}
+
+func (c *GrpcConfig) getGracefulTimeout() time.Duration {
+	if c.GracefulTimeout <= 0 {
+		return defaultGracefulTimeout
+	}
+	return c.GracefulTimeout
+}
+
+// GracefulTimeoutFromEnv returns the drain timeout GRPC_GRACEFUL_TIMEOUT
+// sets, or the default one, e.g. to size the stop timeout of the service.
+func GracefulTimeoutFromEnv() time.Duration {
+	var c struct {
+		GracefulTimeout time.Duration `env:"GRPC_GRACEFUL_TIMEOUT,opt"`
+	}
+	if err := cfg.GetFromEnv(&c); err != nil || c.GracefulTimeout <= 0 {
+		return defaultGracefulTimeout
+	}
+	return c.GracefulTimeout
+}
+
+func (c *GrpcConfig) tlsConfig() *tlstool.Config {
+	if c.TLSCert == "" && c.TLSKey == "" {
+		return nil
+	}
+	return &tlstool.Config{
+		Cert:              c.TLSCert,
+		Key:               c.TLSKey,
+		CA:                c.TLSClientCA,
+		RequireClientCert: c.TLSRequireClientCert,
+	}
+}
+
 func GetConfigFromEnv() (*GrpcConfig, error) {
 	var mycfg GrpcConfig
 	err := cfg.GetFromEnv(&mycfg)
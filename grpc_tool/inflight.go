@@ -0,0 +1,33 @@
+package grpc_tool
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// inflight counts the RPCs that are currently being handled by the server.
+type inflight struct {
+	count atomic.Int64
+}
+
+func (i *inflight) Count() int64 {
+	return i.count.Load()
+}
+
+func (i *inflight) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		i.count.Add(1)
+		defer i.count.Add(-1)
+		return handler(srv, ss)
+	}
+}
+
+func (i *inflight) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		i.count.Add(1)
+		defer i.count.Add(-1)
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,146 @@
+package interceptor
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ClientInterceptor is the client side counterpart of Interceptor. Either
+// interceptor may be nil when it does not apply.
+type ClientInterceptor interface {
+	StreamClientInterceptor() grpc.StreamClientInterceptor
+	UnaryClientInterceptor() grpc.UnaryClientInterceptor
+}
+
+func NewSimpleClientInterceptor(
+	stream grpc.StreamClientInterceptor,
+	unary grpc.UnaryClientInterceptor) ClientInterceptor {
+	return &simpleClientInterceptor{
+		stream: stream,
+		unary:  unary,
+	}
+}
+
+type simpleClientInterceptor struct {
+	stream grpc.StreamClientInterceptor
+	unary  grpc.UnaryClientInterceptor
+}
+
+func (i *simpleClientInterceptor) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return i.stream
+}
+
+func (i *simpleClientInterceptor) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return i.unary
+}
+
+// NewDeadlineInterceptor sets a timeout on unary calls whose context has no
+// deadline.
+func NewDeadlineInterceptor(timeout time.Duration) ClientInterceptor {
+	return NewSimpleClientInterceptor(nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			if _, ok := ctx.Deadline(); !ok {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+}
+
+// NewMetadataPropagationInterceptor forwards the given keys of the incoming
+// metadata of a server call to the outgoing calls made while handling it.
+func NewMetadataPropagationInterceptor(keys ...string) ClientInterceptor {
+	propagate := func(ctx context.Context) context.Context {
+		in, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return ctx
+		}
+		out, _ := metadata.FromOutgoingContext(ctx)
+		var pairs []string
+		for _, key := range keys {
+			if len(out.Get(key)) > 0 {
+				continue
+			}
+			for _, v := range in.Get(key) {
+				pairs = append(pairs, key, v)
+			}
+		}
+		if len(pairs) == 0 {
+			return ctx
+		}
+		return metadata.AppendToOutgoingContext(ctx, pairs...)
+	}
+	return NewSimpleClientInterceptor(
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(propagate(ctx), desc, cc, method, opts...)
+		},
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(propagate(ctx), method, req, reply, cc, opts...)
+		})
+}
+
+// NewRetryInterceptor retries unary calls failing with one of retryable
+// codes, Unavailable by default, up to maxAttempts in total. backoff returns
+// the delay before each retry, counted from 0. Retries stop once the call
+// context is done.
+func NewRetryInterceptor(maxAttempts int, backoff func(retries int) time.Duration, retryable ...codes.Code) ClientInterceptor {
+	if len(retryable) == 0 {
+		retryable = []codes.Code{codes.Unavailable}
+	}
+	isRetryable := func(err error) bool {
+		c := status.Code(err)
+		for _, r := range retryable {
+			if c == r {
+				return true
+			}
+		}
+		return false
+	}
+	return NewSimpleClientInterceptor(nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			var err error
+			for attempt := 0; attempt < maxAttempts; attempt++ {
+				if attempt > 0 {
+					timer := time.NewTimer(backoff(attempt - 1))
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return err
+					case <-timer.C:
+					}
+				}
+				err = invoker(ctx, method, req, reply, cc, opts...)
+				if err == nil || !isRetryable(err) || ctx.Err() != nil {
+					return err
+				}
+			}
+			return err
+		})
+}
+
+type Logger interface {
+	Infof(format string, a ...any)
+}
+
+// NewClientLogInterceptor logs the method, status code and duration of every
+// outgoing call.
+func NewClientLogInterceptor(l Logger) ClientInterceptor {
+	return NewSimpleClientInterceptor(
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			stream, err := streamer(ctx, desc, cc, method, opts...)
+			l.Infof("grpc client stream [%s] target [%s] code [%s]", method, cc.Target(), status.Code(err))
+			return stream, err
+		},
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			start := time.Now()
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			l.Infof("grpc client call [%s] target [%s] code [%s] duration [%s]", method, cc.Target(), status.Code(err), time.Since(start))
+			return err
+		})
+}
@@ -37,10 +37,11 @@ func IsReflectMethod(m string) bool {
 	return m == "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"
 }
 
+// NewServerStream returns stream with its context replaced by ctx.
 func NewServerStream(ctx context.Context, stream grpc.ServerStream) grpc.ServerStream {
 	return &serverStream{
 		ServerStream: stream,
-		ctx:          stream.Context(),
+		ctx:          ctx,
 	}
 }
 
@@ -48,3 +49,7 @@ type serverStream struct {
 	grpc.ServerStream
 	ctx context.Context
 }
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
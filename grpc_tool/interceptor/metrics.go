@@ -0,0 +1,62 @@
+package interceptor
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// NewMetricsInterceptor records the count, latency and in-flight number of
+// the calls served by service on reg, labelled by method and status code.
+func NewMetricsInterceptor(service string, reg prometheus.Registerer) (Interceptor, error) {
+	constLabels := prometheus.Labels{"service": service}
+	handled := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "grpc_server_handled_total",
+		Help:        "Total number of gRPC calls handled by the server.",
+		ConstLabels: constLabels,
+	}, []string{"method", "code"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        "grpc_server_handling_seconds",
+		Help:        "Latency of gRPC calls handled by the server.",
+		ConstLabels: constLabels,
+		Buckets:     prometheus.DefBuckets,
+	}, []string{"method", "code"})
+	inFlight := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "grpc_server_in_flight",
+		Help:        "Number of gRPC calls being handled by the server.",
+		ConstLabels: constLabels,
+	}, []string{"method"})
+	for _, c := range []prometheus.Collector{handled, duration, inFlight} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	observe := func(method string, start time.Time, err error) {
+		code := status.Code(err).String()
+		handled.WithLabelValues(method, code).Inc()
+		duration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+	}
+	return NewSimpleInterceptor(
+		func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			gauge := inFlight.WithLabelValues(info.FullMethod)
+			gauge.Inc()
+			defer gauge.Dec()
+			start := time.Now()
+			err := handler(srv, ss)
+			observe(info.FullMethod, start, err)
+			return err
+		},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			gauge := inFlight.WithLabelValues(info.FullMethod)
+			gauge.Inc()
+			defer gauge.Dec()
+			start := time.Now()
+			resp, err := handler(ctx, req)
+			observe(info.FullMethod, start, err)
+			return resp, err
+		},
+	), nil
+}
@@ -0,0 +1,39 @@
+package interceptor
+
+import (
+	"context"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PanicHandler is called with the recovered value and stack of a panic in
+// the handler of method.
+type PanicHandler func(ctx context.Context, method string, recovered any, stack []byte)
+
+// NewRecoveryInterceptor recovers the panics of the handlers, reports them
+// to onPanic and fails the call with codes.Internal.
+func NewRecoveryInterceptor(onPanic PanicHandler) Interceptor {
+	recoverTo := func(ctx context.Context, method string, err *error) {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if onPanic != nil {
+			onPanic(ctx, method, r, debug.Stack())
+		}
+		*err = status.Error(codes.Internal, "internal error")
+	}
+	return NewSimpleInterceptor(
+		func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+			defer recoverTo(ss.Context(), info.FullMethod, &err)
+			return handler(srv, ss)
+		},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+			defer recoverTo(ctx, info.FullMethod, &err)
+			return handler(ctx, req)
+		},
+	)
+}
@@ -0,0 +1,154 @@
+package interceptor
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const tracerName = "github.com/94peter/microservice/grpc_tool/interceptor"
+
+// metadataCarrier adapts metadata.MD to the otel propagators.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func rpcAttributes(method string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("rpc.system", "grpc")}
+	if service, m, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/"); ok {
+		attrs = append(attrs, attribute.String("rpc.service", service), attribute.String("rpc.method", m))
+	}
+	return attrs
+}
+
+func endSpan(span trace.Span, err error) {
+	s, _ := status.FromError(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(s.Code())))
+	if err != nil {
+		span.SetStatus(otelCodes.Error, s.Message())
+	}
+	span.End()
+}
+
+// NewTracingInterceptor starts a server span per call, continuing the W3C
+// trace context of the incoming metadata.
+func NewTracingInterceptor() Interceptor {
+	tracer := otel.Tracer(tracerName)
+	start := func(ctx context.Context, method string) (context.Context, trace.Span) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+		return tracer.Start(ctx, strings.TrimPrefix(method, "/"),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(rpcAttributes(method)...),
+		)
+	}
+	return NewSimpleInterceptor(
+		func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, span := start(ss.Context(), info.FullMethod)
+			err := handler(srv, NewServerStream(ctx, ss))
+			endSpan(span, err)
+			return err
+		},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, span := start(ctx, info.FullMethod)
+			resp, err := handler(ctx, req)
+			endSpan(span, err)
+			return resp, err
+		},
+	)
+}
+
+// NewClientTracingInterceptor starts a client span per call and sends its
+// W3C trace context in the outgoing metadata.
+func NewClientTracingInterceptor() ClientInterceptor {
+	tracer := otel.Tracer(tracerName)
+	start := func(ctx context.Context, method string) (context.Context, trace.Span) {
+		ctx, span := tracer.Start(ctx, strings.TrimPrefix(method, "/"),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(rpcAttributes(method)...),
+		)
+		md, ok := metadata.FromOutgoingContext(ctx)
+		if ok {
+			md = md.Copy()
+		} else {
+			md = metadata.MD{}
+		}
+		otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+		return metadata.NewOutgoingContext(ctx, md), span
+	}
+	return NewSimpleClientInterceptor(
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			ctx, span := start(ctx, method)
+			stream, err := streamer(ctx, desc, cc, method, opts...)
+			if err != nil {
+				endSpan(span, err)
+				return nil, err
+			}
+			traced := &tracedClientStream{ClientStream: stream, span: span}
+			// ends the span of a stream cancelled or abandoned before EOF
+			traced.stop = context.AfterFunc(ctx, func() {
+				traced.end(status.FromContextError(ctx.Err()).Err())
+			})
+			return traced, nil
+		},
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			ctx, span := start(ctx, method)
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			endSpan(span, err)
+			return err
+		},
+	)
+}
+
+// tracedClientStream ends its span once the stream is finished or its
+// context is done, whichever comes first.
+type tracedClientStream struct {
+	grpc.ClientStream
+	span trace.Span
+	once sync.Once
+	stop func() bool
+}
+
+func (s *tracedClientStream) end(err error) {
+	s.once.Do(func() { endSpan(s.span, err) })
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		return nil
+	}
+	s.stop()
+	if err == io.EOF {
+		s.end(nil)
+	} else {
+		s.end(err)
+	}
+	return err
+}
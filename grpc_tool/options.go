@@ -0,0 +1,100 @@
+package grpc_tool
+
+import (
+	"time"
+
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/tlstool"
+	"google.golang.org/grpc"
+)
+
+type ConnOption func(*connOptions)
+
+type connOptions struct {
+	tls      *tlstool.Config
+	dialOpts []grpc.DialOption
+
+	resolver        Resolver
+	resolveInterval time.Duration
+	balancer        string
+	retry           *RetryPolicy
+	methods         []*MethodPolicy
+}
+
+func newConnOptions(opts []ConnOption) *connOptions {
+	o := &connOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithTLS dials with TLS. The CA of cfg replaces the system roots, its
+// cert/key pair is sent as client certificate and ServerName overrides the
+// name verified against the server certificate.
+func WithTLS(cfg *tlstool.Config) ConnOption {
+	return func(o *connOptions) {
+		o.tls = cfg
+	}
+}
+
+func WithDialOptions(opts ...grpc.DialOption) ConnOption {
+	return func(o *connOptions) {
+		o.dialOpts = append(o.dialOpts, opts...)
+	}
+}
+
+// WithResolver resolves the connection addresses with r instead of dialing
+// the address directly. r is polled every interval, 30s when zero.
+func WithResolver(r Resolver, interval time.Duration) ConnOption {
+	return func(o *connOptions) {
+		o.resolver = r
+		o.resolveInterval = interval
+	}
+}
+
+// WithBalancer selects the load balancing policy among the resolved
+// addresses: BalancerPickFirst, BalancerRoundRobin or BalancerLeastRequest.
+func WithBalancer(name string) ConnOption {
+	return func(o *connOptions) {
+		o.balancer = name
+	}
+}
+
+// target returns the dial target and options for address.
+func (o *connOptions) target(address string) (string, []grpc.DialOption, error) {
+	dialOpts := []grpc.DialOption{grpc.WithResolvers(schemeBuilders...)}
+	if o.resolver != nil {
+		builder := newCustomResolverBuilder(o.resolver, o.resolveInterval)
+		dialOpts = append(dialOpts, grpc.WithResolvers(builder))
+		address = builder.Scheme() + ":///" + address
+	}
+	methods, methodOpts, err := o.methodOptions()
+	if err != nil {
+		return "", nil, err
+	}
+	dialOpts = append(dialOpts, methodOpts...)
+	sc, err := o.serviceConfig(methods)
+	if err != nil {
+		return "", nil, err
+	}
+	if sc != "" {
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(sc))
+	}
+	return address, dialOpts, nil
+}
+
+// WithClientInterceptors chains interceptors on the outgoing calls, the
+// first one being the outermost.
+func WithClientInterceptors(interceptors ...interceptor.ClientInterceptor) ConnOption {
+	return func(o *connOptions) {
+		for _, i := range interceptors {
+			if unary := i.UnaryClientInterceptor(); unary != nil {
+				o.dialOpts = append(o.dialOpts, grpc.WithChainUnaryInterceptor(unary))
+			}
+			if stream := i.StreamClientInterceptor(); stream != nil {
+				o.dialOpts = append(o.dialOpts, grpc.WithChainStreamInterceptor(stream))
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package grpc_tool
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// panicCounter counts the panics recovered from the service handlers and
+// reports them to the handlers of the config and of the server.
+type panicCounter struct {
+	count    atomic.Int64
+	cfg      *GrpcConfig
+	handlers []interceptor.PanicHandler
+}
+
+func (p *panicCounter) Count() int64 {
+	return p.count.Load()
+}
+
+func (p *panicCounter) onPanic(ctx context.Context, method string, recovered any, stack []byte) {
+	p.count.Add(1)
+	p.cfg.onPanic(ctx, method, recovered, stack)
+	for _, h := range p.handlers {
+		h(ctx, method, recovered, stack)
+	}
+}
+
+// newPanicMetric counts the panics in panics_total with transport grpc, as
+// the api counts the panics of its handlers.
+func newPanicMetric(service string, reg prometheus.Registerer) (interceptor.PanicHandler, error) {
+	panics := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "panics_total",
+		Help:        "Total number of panics recovered from handlers.",
+		ConstLabels: prometheus.Labels{"service": service},
+	}, []string{"transport"})
+	if err := reg.Register(panics); err != nil {
+		return nil, err
+	}
+	counter := panics.WithLabelValues("grpc")
+	return func(context.Context, string, any, []byte) {
+		counter.Inc()
+	}, nil
+}
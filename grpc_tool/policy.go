@@ -0,0 +1,197 @@
+package grpc_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// MethodPolicy overrides the connection wide policies for the methods of
+// Service, or only Method when set. Timeout caps the caller deadline. Retry
+// and Hedging are exclusive.
+type MethodPolicy struct {
+	Service string         `yaml:"service"`
+	Method  string         `yaml:"method"`
+	Timeout time.Duration  `yaml:"timeout"`
+	Retry   *RetryPolicy   `yaml:"retry"`
+	Hedging *HedgingPolicy `yaml:"hedging"`
+}
+
+// HedgingPolicy sends up to MaxAttempts copies of a unary call, a new one
+// every HedgingDelay or as soon as an attempt fails with one of
+// NonFatalStatusCodes, and keeps the first success. Any other failure is
+// returned immediately.
+type HedgingPolicy struct {
+	MaxAttempts         int           `yaml:"maxAttempts"`
+	HedgingDelay        time.Duration `yaml:"hedgingDelay"`
+	NonFatalStatusCodes []string      `yaml:"nonFatalStatusCodes"`
+}
+
+// WithMethodPolicies applies per method retry, hedging and timeout policies.
+func WithMethodPolicies(policies ...*MethodPolicy) ConnOption {
+	return func(o *connOptions) {
+		o.methods = append(o.methods, policies...)
+	}
+}
+
+func (p *MethodPolicy) validate() error {
+	if p.Service == "" {
+		return errors.New("method policy service is empty")
+	}
+	if p.Retry != nil && p.Hedging != nil {
+		return fmt.Errorf("method policy [%s] has both retry and hedging", p.fullMethod())
+	}
+	return nil
+}
+
+// fullMethod returns "/service/method", or "/service/" for the whole service.
+func (p *MethodPolicy) fullMethod() string {
+	return "/" + p.Service + "/" + p.Method
+}
+
+func (p *MethodPolicy) methodConfig() (methodConfig, error) {
+	mc := methodConfig{
+		Name: []methodName{{Service: p.Service, Method: p.Method}},
+	}
+	if p.Timeout > 0 {
+		mc.Timeout = durationJSON(p.Timeout, 0)
+	}
+	if p.Retry != nil {
+		retry, err := p.Retry.toJSON()
+		if err != nil {
+			return mc, fmt.Errorf("method policy [%s] error: %w", p.fullMethod(), err)
+		}
+		mc.RetryPolicy = retry
+	}
+	return mc, nil
+}
+
+type hedging struct {
+	maxAttempts int
+	delay       time.Duration
+	nonFatal    map[codes.Code]bool
+}
+
+func (p *HedgingPolicy) compile() (*hedging, error) {
+	if p.MaxAttempts < 2 {
+		return nil, errors.New("hedging maxAttempts must be at least 2")
+	}
+	names, err := statusCodeNames(p.NonFatalStatusCodes)
+	if err != nil {
+		return nil, err
+	}
+	h := &hedging{
+		maxAttempts: p.MaxAttempts,
+		delay:       p.HedgingDelay,
+		nonFatal:    make(map[codes.Code]bool, len(names)),
+	}
+	for _, name := range names {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(`"` + name + `"`)); err != nil {
+			return nil, err
+		}
+		h.nonFatal[code] = true
+	}
+	return h, nil
+}
+
+// hedgingInterceptor hedges the unary calls matching policies, keyed by
+// fullMethod. gRPC does not implement the hedging policy of the service
+// config, so it is done on the client side.
+func hedgingInterceptor(policies map[string]*hedging) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		h, ok := policies[method]
+		if !ok {
+			h, ok = policies[method[:strings.LastIndex(method, "/")+1]]
+		}
+		msg, isProto := reply.(proto.Message)
+		if !ok || !isProto {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		return h.invoke(ctx, method, req, msg, cc, invoker, opts...)
+	}
+}
+
+func (h *hedging) invoke(ctx context.Context, method string, req interface{}, reply proto.Message, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	type result struct {
+		reply proto.Message
+		err   error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, h.maxAttempts)
+	launched, pending := 0, 0
+	var next <-chan time.Time
+	launch := func() {
+		launched++
+		pending++
+		r := reply.ProtoReflect().New().Interface()
+		go func() {
+			results <- result{reply: r, err: invoker(ctx, method, req, r, cc, opts...)}
+		}()
+		if launched < h.maxAttempts {
+			next = time.After(h.delay)
+		} else {
+			next = nil
+		}
+	}
+	launch()
+	var err error
+	for {
+		select {
+		case <-next:
+			launch()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				proto.Reset(reply)
+				proto.Merge(reply, r.reply)
+				return nil
+			}
+			err = r.err
+			if !h.nonFatal[status.Code(err)] || ctx.Err() != nil {
+				return err
+			}
+			if launched < h.maxAttempts {
+				launch()
+			} else if pending == 0 {
+				return err
+			}
+		}
+	}
+}
+
+// methodOptions returns the method configs of the service config and the
+// hedging interceptor, if any.
+func (o *connOptions) methodOptions() ([]methodConfig, []grpc.DialOption, error) {
+	var mcs []methodConfig
+	hedges := make(map[string]*hedging)
+	for _, p := range o.methods {
+		if err := p.validate(); err != nil {
+			return nil, nil, err
+		}
+		mc, err := p.methodConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		mcs = append(mcs, mc)
+		if p.Hedging != nil {
+			h, err := p.Hedging.compile()
+			if err != nil {
+				return nil, nil, fmt.Errorf("method policy [%s] error: %w", p.fullMethod(), err)
+			}
+			hedges[p.fullMethod()] = h
+		}
+	}
+	if len(hedges) == 0 {
+		return mcs, nil, nil
+	}
+	return mcs, []grpc.DialOption{grpc.WithChainUnaryInterceptor(hedgingInterceptor(hedges))}, nil
+}
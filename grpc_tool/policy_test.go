@@ -0,0 +1,154 @@
+package grpc_tool
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestMethodPolicyServiceConfig(t *testing.T) {
+	var conf DownstreamConf
+	err := yaml.Unmarshal([]byte(`
+address: 127.0.0.1:1
+retry:
+  maxAttempts: 2
+methods:
+  - service: billing.Billing
+    method: Charge
+    timeout: 1500ms
+    retry:
+      maxAttempts: 4
+      retryableStatusCodes: [Unavailable, Aborted]
+  - service: billing.Billing
+    method: Quote
+    hedging:
+      maxAttempts: 3
+      hedgingDelay: 50ms
+`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := newConnOptions(conf.options())
+	_, dialOpts, err := o.target(conf.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods, _, _ := o.methodOptions()
+	sc, err := o.serviceConfig(methods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed serviceConfig
+	if err := json.Unmarshal([]byte(sc), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.MethodConfig) != 3 {
+		t.Fatalf("expected 3 method configs, got %s", sc)
+	}
+	charge := parsed.MethodConfig[1]
+	if charge.Timeout != "1.5s" || charge.RetryPolicy.MaxAttempts != 4 || len(charge.RetryPolicy.RetryableStatusCodes) != 2 {
+		t.Errorf("unexpected Charge config %+v", charge)
+	}
+	if quote := parsed.MethodConfig[2]; quote.RetryPolicy != nil {
+		t.Errorf("hedged method must not be retried: %+v", quote)
+	}
+	// scheme resolvers, service config and hedging interceptor
+	if len(dialOpts) != 3 {
+		t.Errorf("expected 3 dial options, got %d", len(dialOpts))
+	}
+
+	o = newConnOptions([]ConnOption{WithMethodPolicies(&MethodPolicy{
+		Service: "billing.Billing",
+		Retry:   &RetryPolicy{MaxAttempts: 2},
+		Hedging: &HedgingPolicy{MaxAttempts: 2},
+	})})
+	if _, _, err := o.target("127.0.0.1:1"); err == nil {
+		t.Error("expected error for retry and hedging on the same method")
+	}
+}
+
+func TestHedging(t *testing.T) {
+	h, err := (&HedgingPolicy{
+		MaxAttempts:         3,
+		HedgingDelay:        10 * time.Millisecond,
+		NonFatalStatusCodes: []string{"Unavailable"},
+	}).compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int64
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		switch calls.Add(1) {
+		case 1:
+			return status.Error(codes.Unavailable, "busy")
+		case 2:
+			// hangs until the third attempt wins
+			<-ctx.Done()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		reply.(*healthpb.HealthCheckResponse).Status = healthpb.HealthCheckResponse_SERVING
+		return nil
+	}
+	intercept := hedgingInterceptor(map[string]*hedging{"/grpc.health.v1.Health/": h})
+	start := time.Now()
+	// the second attempt starts on the non fatal failure, the third after the
+	// delay
+	reply := &healthpb.HealthCheckResponse{}
+	err = intercept(context.Background(), "/grpc.health.v1.Health/Check", &healthpb.HealthCheckRequest{}, reply, nil, invoker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != healthpb.HealthCheckResponse_SERVING || calls.Load() != 3 {
+		t.Errorf("unexpected reply %v after %d calls", reply, calls.Load())
+	}
+	if time.Since(start) > time.Second {
+		t.Error("hedging took too long")
+	}
+
+	calls.Store(0)
+	fatal := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls.Add(1)
+		return status.Error(codes.InvalidArgument, "bad")
+	}
+	err = intercept(context.Background(), "/grpc.health.v1.Health/Check", &healthpb.HealthCheckRequest{}, reply, nil, fatal)
+	if status.Code(err) != codes.InvalidArgument || calls.Load() != 1 {
+		t.Errorf("expected one fatal attempt, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestAttemptMetrics(t *testing.T) {
+	addr, _ := startTestServer(t)
+	m := NewAttemptMetrics()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewConnection(ctx, addr, WithAttemptMetrics(m), WithMethodPolicies(&MethodPolicy{
+		Service: "grpc.health.v1.Health",
+		Hedging: &HedgingPolicy{MaxAttempts: 2, HedgingDelay: time.Second},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetMetric()[0].GetCounter().GetValue() != 1 {
+		t.Errorf("unexpected metrics %v", families)
+	}
+}
@@ -0,0 +1,304 @@
+package grpc_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/94peter/microservice/di"
+	"github.com/94peter/microservice/tlstool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultDialTimeout = 10 * time.Second
+
+	downstreamEnvPrefix = "GRPC_DOWNSTREAM_"
+)
+
+// DownstreamConf configures the connection to a named downstream service.
+type DownstreamConf struct {
+	Address  string          `yaml:"address"`
+	Balancer string          `yaml:"balancer"`
+	Timeout  time.Duration   `yaml:"timeout"`
+	TLS      *tlstool.Config `yaml:"tls"`
+	Retry    *RetryPolicy    `yaml:"retry"`
+	Methods  []*MethodPolicy `yaml:"methods"`
+	Breaker  *BreakerConfig  `yaml:"circuitBreaker"`
+}
+
+func (c *DownstreamConf) options() []ConnOption {
+	var opts []ConnOption
+	if c.Balancer != "" {
+		opts = append(opts, WithBalancer(c.Balancer))
+	}
+	if c.TLS != nil {
+		opts = append(opts, WithTLS(c.TLS))
+	}
+	if c.Retry != nil {
+		opts = append(opts, WithRetryPolicy(c.Retry))
+	}
+	if len(c.Methods) > 0 {
+		opts = append(opts, WithMethodPolicies(c.Methods...))
+	}
+	return opts
+}
+
+// applyEnv overrides the config with GRPC_DOWNSTREAM_<NAME>_<KEY> variables,
+// e.g. GRPC_DOWNSTREAM_BILLING_ADDRESS.
+func (c *DownstreamConf) applyEnv(name string) error {
+	prefix := downstreamEnvPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name)) + "_"
+	if v := os.Getenv(prefix + "ADDRESS"); v != "" {
+		c.Address = v
+	}
+	if v := os.Getenv(prefix + "BALANCER"); v != "" {
+		c.Balancer = v
+	}
+	if v := os.Getenv(prefix + "TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return errors.New("environmental variable " + prefix + "TIMEOUT must be a duration")
+		}
+		c.Timeout = d
+	}
+	tlsEnv := map[string]*string{}
+	if c.TLS == nil {
+		c.TLS = &tlstool.Config{}
+		defer func() {
+			if *c.TLS == (tlstool.Config{}) {
+				c.TLS = nil
+			}
+		}()
+	}
+	tlsEnv[prefix+"TLS_CA"] = &c.TLS.CA
+	tlsEnv[prefix+"TLS_CERT"] = &c.TLS.Cert
+	tlsEnv[prefix+"TLS_KEY"] = &c.TLS.Key
+	tlsEnv[prefix+"TLS_SERVER_NAME"] = &c.TLS.ServerName
+	for key, field := range tlsEnv {
+		if v := os.Getenv(key); v != "" {
+			*field = v
+		}
+	}
+	return nil
+}
+
+// ErrConnPoolClosed is returned by a pool used after Close, e.g. by a
+// request that outlived the di.Reloader CloseDelay of its DI. Get the pool
+// from the current DI of the reloader to retry.
+var ErrConnPoolClosed = errors.New("connection pool is closed")
+
+// ConnPool shares lazily dialed connections to named downstreams. It is a
+// prometheus.Collector exporting the state of their circuit breakers.
+type ConnPool struct {
+	confs    map[string]*DownstreamConf
+	opts     []ConnOption
+	breakers map[string]*CircuitBreakers
+
+	mu     sync.Mutex
+	conns  map[string]*poolConn
+	closed bool
+}
+
+type poolConn struct {
+	mu   sync.Mutex
+	conn Connection
+}
+
+// NewConnPool returns a pool of the downstreams in confs, overridden by the
+// GRPC_DOWNSTREAM_<NAME>_* environment variables. opts apply to every
+// connection before the options of its config.
+func NewConnPool(confs map[string]*DownstreamConf, opts ...ConnOption) (*ConnPool, error) {
+	p := &ConnPool{
+		confs:    make(map[string]*DownstreamConf, len(confs)),
+		opts:     opts,
+		breakers: make(map[string]*CircuitBreakers),
+		conns:    make(map[string]*poolConn),
+	}
+	for name, c := range confs {
+		conf := DownstreamConf{}
+		if c != nil {
+			conf = *c
+		}
+		if err := conf.applyEnv(name); err != nil {
+			return nil, err
+		}
+		if conf.Address == "" {
+			return nil, fmt.Errorf("downstream [%s] address is empty", name)
+		}
+		if conf.Breaker != nil {
+			b, err := NewCircuitBreakers(conf.Breaker)
+			if err != nil {
+				return nil, fmt.Errorf("downstream [%s] circuit breaker: %w", name, err)
+			}
+			p.breakers[name] = b
+		}
+		p.confs[name] = &conf
+	}
+	return p, nil
+}
+
+// Get returns the connection to the named downstream, dialing it on first use.
+func (p *ConnPool) Get(name string) (Connection, error) {
+	conf, ok := p.confs[name]
+	if !ok {
+		return nil, fmt.Errorf("downstream [%s] not found", name)
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrConnPoolClosed
+	}
+	pc, ok := p.conns[name]
+	if !ok {
+		pc = &poolConn{}
+		p.conns[name] = pc
+	}
+	p.mu.Unlock()
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.conn != nil {
+		return pc.conn, nil
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	opts := append(append([]ConnOption{}, p.opts...), conf.options()...)
+	if b, ok := p.breakers[name]; ok {
+		opts = append(opts, WithCircuitBreaker(b))
+	}
+	conn, err := NewConnection(ctx, conf.Address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("downstream [%s]: %w", name, err)
+	}
+	pc.conn = conn
+	return conn, nil
+}
+
+// CircuitBreakers returns the circuit breakers of the named downstream, nil
+// when it has none.
+func (p *ConnPool) CircuitBreakers(name string) *CircuitBreakers {
+	return p.breakers[name]
+}
+
+func (p *ConnPool) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolBreakerStateDesc
+}
+
+func (p *ConnPool) Collect(ch chan<- prometheus.Metric) {
+	for name, b := range p.breakers {
+		for _, s := range b.States() {
+			ch <- prometheus.MustNewConstMetric(poolBreakerStateDesc, prometheus.GaugeValue, float64(s.State), name, s.Target, s.Method)
+		}
+	}
+}
+
+// Close closes every dialed connection. Get fails afterwards.
+func (p *ConnPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	conns := p.conns
+	p.conns = make(map[string]*poolConn)
+	p.mu.Unlock()
+
+	var errs []error
+	for name, pc := range conns {
+		pc.mu.Lock()
+		if pc.conn != nil {
+			if err := pc.conn.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close downstream [%s] fail: %w", name, err))
+			}
+		}
+		pc.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// Run closes the pool once ctx is done, so it can be passed to RunService.
+func (p *ConnPool) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return p.Close()
+}
+
+type ConnPoolDI interface {
+	GetConnPool() *ConnPool
+}
+
+// ConnPoolConf is inlined in a service DI to configure its downstreams:
+//
+//	type MyDI struct {
+//		di.CommonServiceDI
+//		grpc_tool.ConnPoolConf `yaml:",inline"`
+//	}
+//
+//	grpcDownstreams:
+//	  billing:
+//	    address: dns:///billing:9090
+//	    balancer: round_robin
+//	    circuitBreaker:
+//	      failureRatio: 0.5
+//	      coolDown: 30s
+type ConnPoolConf struct {
+	Downstreams map[string]*DownstreamConf `yaml:"grpcDownstreams"`
+
+	once sync.Once
+	mu   sync.Mutex
+	pool *ConnPool
+	err  error
+}
+
+// GetConnPool returns the pool of the configured downstreams. It is nil
+// when the config is invalid, see ConnPoolErr.
+func (c *ConnPoolConf) GetConnPool() *ConnPool {
+	c.once.Do(func() {
+		c.pool, c.err = NewConnPool(c.Downstreams)
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pool
+}
+
+// Close closes the pool, if any, e.g. when a reloaded DI replaces the one
+// inlining c. GetConnPool returns nil and ConnPoolErr ErrConnPoolClosed
+// afterwards.
+func (c *ConnPoolConf) Close() error {
+	c.once.Do(func() {})
+	c.mu.Lock()
+	pool := c.pool
+	c.pool, c.err = nil, ErrConnPoolClosed
+	c.mu.Unlock()
+	if pool == nil {
+		return nil
+	}
+	return pool.Close()
+}
+
+func (c *ConnPoolConf) ConnPoolErr() error {
+	c.GetConnPool()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// GetConnPoolFromCtx returns the pool of the DI injected into ctx.
+func GetConnPoolFromCtx(ctx context.Context) (*ConnPool, error) {
+	servDi, ok := di.GetDiFromCtx[di.DI](ctx).(ConnPoolDI)
+	if !ok {
+		return nil, errors.New("di does not provide a connection pool")
+	}
+	pool := servDi.GetConnPool()
+	if pool == nil {
+		if d, ok := servDi.(interface{ ConnPoolErr() error }); ok && d.ConnPoolErr() != nil {
+			return nil, d.ConnPoolErr()
+		}
+		return nil, errors.New("connection pool is not configured")
+	}
+	return pool, nil
+}
@@ -0,0 +1,152 @@
+package grpc_tool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/94peter/microservice/di"
+	"github.com/prometheus/client_golang/prometheus"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestConnPool(t *testing.T) {
+	addr, _ := startTestServer(t)
+
+	var conf struct {
+		ConnPoolConf `yaml:",inline"`
+	}
+	err := yaml.Unmarshal([]byte(`
+grpcDownstreams:
+  billing:
+    address: 127.0.0.1:1
+    timeout: 2s
+    balancer: round_robin
+    retry:
+      maxAttempts: 3
+      retryableStatusCodes: [Unavailable, RESOURCE_EXHAUSTED]
+`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GRPC_DOWNSTREAM_BILLING_ADDRESS", addr)
+	defer os.Unsetenv("GRPC_DOWNSTREAM_BILLING_ADDRESS")
+
+	pool := conf.GetConnPool()
+	if err := conf.ConnPoolErr(); err != nil {
+		t.Fatal(err)
+	}
+	conn1, err := pool.Get("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn2, err := pool.Get("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn1 != conn2 {
+		t.Error("expected the connection to be shared")
+	}
+	if _, err := pool.Get("shipping"); err == nil {
+		t.Error("expected error for unknown downstream")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pool.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if conn1.IsValid() {
+		t.Error("expected the connection to be closed")
+	}
+	if _, err := pool.Get("billing"); err == nil {
+		t.Error("expected error after close")
+	}
+}
+
+type poolDI struct {
+	ConnPoolConf `yaml:",inline"`
+}
+
+func (d *poolDI) IsConfEmpty() error {
+	return nil
+}
+
+func (d *poolDI) GetService() string {
+	return "test"
+}
+
+func TestConnPoolClosedOnReload(t *testing.T) {
+	addr, _ := startTestServer(t)
+	load := func(d *poolDI) error {
+		d.Downstreams = map[string]*DownstreamConf{"billing": {Address: addr}}
+		return nil
+	}
+	first := &poolDI{}
+	if err := load(first); err != nil {
+		t.Fatal(err)
+	}
+	r := di.NewReloader(first, nil, load)
+	r.CloseDelay = 0
+	oldPool := first.GetConnPool()
+	old, err := oldPool.Get("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oldPool.Get("billing"); !errors.Is(err, ErrConnPoolClosed) {
+		t.Errorf("expected ErrConnPoolClosed from the held pool, got %v", err)
+	}
+	if old.IsValid() {
+		t.Error("expected the connection of the replaced pool to be closed")
+	}
+	if first.GetConnPool() != nil || !errors.Is(first.ConnPoolErr(), ErrConnPoolClosed) {
+		t.Error("expected the replaced pool to be closed")
+	}
+	conn, err := r.Get().GetConnPool().Get("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conn.IsValid() {
+		t.Error("expected the new pool to connect")
+	}
+}
+
+func TestConnPoolMetrics(t *testing.T) {
+	pool, err := NewConnPool(map[string]*DownstreamConf{
+		"billing": {Address: "127.0.0.1:1", Breaker: &BreakerConfig{FailureRatio: 0.5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewCircuitBreakers(&BreakerConfig{FailureRatio: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := breakerKey{target: "127.0.0.1:1", method: "/billing.Billing/Charge"}
+	for _, b := range []*CircuitBreakers{pool.CircuitBreakers("billing"), client} {
+		token, err := b.allow(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.done(token, nil)
+	}
+
+	// the pool and the breakers of another client share a registry
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(pool, client)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]int{}
+	for _, f := range families {
+		names[f.GetName()] = len(f.GetMetric())
+	}
+	if names["grpc_client_circuit_breaker_state"] != 1 || names["grpc_client_downstream_circuit_breaker_state"] != 1 {
+		t.Errorf("unexpected metrics %v", names)
+	}
+}
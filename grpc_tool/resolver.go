@@ -0,0 +1,171 @@
+package grpc_tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	StaticScheme = "static"
+	DNSSRVScheme = "dnssrv"
+
+	defaultResolveInterval = 30 * time.Second
+)
+
+// Resolver looks up the addresses serving a target, e.g. from a service
+// registry. It is polled periodically and whenever gRPC asks to re-resolve.
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+type ResolverFunc func(ctx context.Context) ([]string, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// StaticResolver always resolves to addrs.
+func StaticResolver(addrs ...string) Resolver {
+	return ResolverFunc(func(ctx context.Context) ([]string, error) {
+		return addrs, nil
+	})
+}
+
+// DNSSRVResolver resolves the SRV records of name, e.g.
+// "_grpc._tcp.billing.default.svc.cluster.local".
+func DNSSRVResolver(name string) Resolver {
+	return ResolverFunc(func(ctx context.Context) ([]string, error) {
+		_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, 0, len(srvs))
+		for _, srv := range srvs {
+			addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+		return addrs, nil
+	})
+}
+
+// schemeBuilders resolve the static and dnssrv schemes of NewConnection.
+// They are passed to each connection rather than registered globally, so
+// other users of the scheme names are not affected.
+var schemeBuilders = []resolver.Builder{
+	// static:///host1:port,host2:port
+	&resolverBuilder{
+		scheme: StaticScheme,
+		newResolver: func(endpoint string) Resolver {
+			return StaticResolver(strings.Split(endpoint, ",")...)
+		},
+		interval: defaultResolveInterval,
+	},
+	// dnssrv:///_grpc._tcp.service.namespace.svc.cluster.local
+	&resolverBuilder{
+		scheme:      DNSSRVScheme,
+		newResolver: DNSSRVResolver,
+		interval:    defaultResolveInterval,
+	},
+}
+
+var schemeSeq atomic.Int64
+
+func newCustomResolverBuilder(r Resolver, interval time.Duration) *resolverBuilder {
+	return &resolverBuilder{
+		scheme: fmt.Sprintf("microservice-%d", schemeSeq.Add(1)),
+		newResolver: func(string) Resolver {
+			return r
+		},
+		interval: interval,
+	}
+}
+
+// resolverBuilder adapts a Resolver to a gRPC resolver.Builder.
+type resolverBuilder struct {
+	scheme      string
+	newResolver func(endpoint string) Resolver
+	interval    time.Duration
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return b.scheme
+}
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &pollingResolver{
+		resolver: b.newResolver(target.Endpoint()),
+		cc:       cc,
+		interval: b.interval,
+		cancel:   cancel,
+		trigger:  make(chan struct{}, 1),
+	}
+	if r.interval <= 0 {
+		r.interval = defaultResolveInterval
+	}
+	r.wg.Add(1)
+	go r.watch(ctx)
+	return r, nil
+}
+
+type pollingResolver struct {
+	resolver Resolver
+	cc       resolver.ClientConn
+	interval time.Duration
+	cancel   context.CancelFunc
+	trigger  chan struct{}
+	wg       sync.WaitGroup
+}
+
+func (r *pollingResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *pollingResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *pollingResolver) watch(ctx context.Context) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.resolve(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.trigger:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *pollingResolver) resolve(ctx context.Context) {
+	addrs, err := r.resolver.Resolve(ctx)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no address resolved")
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			r.cc.ReportError(err)
+		}
+		return
+	}
+	state := resolver.State{Addresses: make([]resolver.Address, 0, len(addrs))}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+	r.cc.UpdateState(state)
+}
@@ -0,0 +1,96 @@
+package grpc_tool
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+const defaultRetryableCode = "UNAVAILABLE"
+
+// RetryPolicy is the retry policy of the gRPC service config, applied by the
+// gRPC client to every method of the connection without a MethodPolicy.
+type RetryPolicy struct {
+	MaxAttempts          int           `yaml:"maxAttempts"`
+	InitialBackoff       time.Duration `yaml:"initialBackoff"`
+	MaxBackoff           time.Duration `yaml:"maxBackoff"`
+	BackoffMultiplier    float64       `yaml:"backoffMultiplier"`
+	RetryableStatusCodes []string      `yaml:"retryableStatusCodes"`
+}
+
+// WithRetryPolicy retries failed calls as described by p.
+func WithRetryPolicy(p *RetryPolicy) ConnOption {
+	return func(o *connOptions) {
+		o.retry = p
+	}
+}
+
+type retryPolicyJSON struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+func (p *RetryPolicy) toJSON() (*retryPolicyJSON, error) {
+	if p.MaxAttempts < 2 {
+		return nil, fmt.Errorf("retry maxAttempts must be at least 2")
+	}
+	retryable, err := statusCodeNames(p.RetryableStatusCodes)
+	if err != nil {
+		return nil, err
+	}
+	if len(retryable) == 0 {
+		retryable = []string{defaultRetryableCode}
+	}
+	r := &retryPolicyJSON{
+		MaxAttempts:          p.MaxAttempts,
+		InitialBackoff:       durationJSON(p.InitialBackoff, 100*time.Millisecond),
+		MaxBackoff:           durationJSON(p.MaxBackoff, time.Second),
+		BackoffMultiplier:    p.BackoffMultiplier,
+		RetryableStatusCodes: retryable,
+	}
+	if r.BackoffMultiplier <= 0 {
+		r.BackoffMultiplier = 2
+	}
+	return r, nil
+}
+
+// statusCodeNames validates names like "UNAVAILABLE" or "Unavailable" and
+// returns them in the upper snake case of the service config.
+func statusCodeNames(names []string) ([]string, error) {
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(`"` + strings.ToUpper(toSnake(name)) + `"`)); err != nil {
+			return nil, fmt.Errorf("unknown status code [%s]", name)
+		}
+		result = append(result, strings.ToUpper(toSnake(name)))
+	}
+	return result, nil
+}
+
+func toSnake(s string) string {
+	if strings.Contains(s, "_") || strings.ToUpper(s) == s {
+		return s
+	}
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// durationJSON formats d as the seconds string of the service config.
+func durationJSON(d, def time.Duration) string {
+	if d <= 0 {
+		d = def
+	}
+	return fmt.Sprintf("%gs", d.Seconds())
+}
@@ -5,59 +5,219 @@ import (
 	"fmt"
 	"net"
 	"strconv"
-	"sync"
+	"time"
 
+	apiErr "github.com/94peter/microservice/apitool/err"
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/health"
+	"github.com/94peter/microservice/requestid"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
-func RunGrpcServ(ctx context.Context, cfg *GrpcConfig) error {
+// Server is a grpc.Server built from GrpcConfig with every service registered.
+type Server struct {
+	*grpc.Server
+
+	cfg     *GrpcConfig
+	counter *inflight
+	panics  *panicCounter
+}
+
+type ServerOption func(*serverOptions)
+
+type serverOptions struct {
+	interceptors  []interceptor.Interceptor
+	panicHandlers []interceptor.PanicHandler
+}
+
+// WithServerInterceptors adds interceptors running before the ones of the
+// config, to this server only.
+func WithServerInterceptors(i ...interceptor.Interceptor) ServerOption {
+	return func(o *serverOptions) {
+		o.interceptors = append(o.interceptors, i...)
+	}
+}
+
+// WithPanicHandler adds h to the panic handlers of this server only, called
+// after the ones of the config.
+func WithPanicHandler(h interceptor.PanicHandler) ServerOption {
+	return func(o *serverOptions) {
+		o.panicHandlers = append(o.panicHandlers, h)
+	}
+}
+
+// NewServer builds the server of cfg without transport credentials, e.g. to
+// be served through ServeHTTP.
+func NewServer(cfg *GrpcConfig, opts ...ServerOption) (*Server, error) {
+	o := &serverOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return newServer(cfg, o)
+}
+
+func newServer(cfg *GrpcConfig, o *serverOptions, opts ...grpc.ServerOption) (*Server, error) {
 	if cfg.registerServiceFunc == nil {
-		return fmt.Errorf("registerServiceFunc must not be nil")
+		return nil, fmt.Errorf("registerServiceFunc must not be nil")
+	}
+	counter := &inflight{}
+	panics := &panicCounter{cfg: cfg, handlers: o.panicHandlers}
+	// the outer recovery covers the interceptors, the inner one the handlers
+	builtin := []interceptor.Interceptor{interceptor.NewRecoveryInterceptor(panics.onPanic), counter, requestid.Interceptor(), interceptor.NewTracingInterceptor(), apiErr.GrpcErrorInterceptor()}
+	var streamInterceptors []grpc.StreamServerInterceptor
+	var unaryInterceptors []grpc.UnaryServerInterceptor
+	all := append(append(builtin, o.interceptors...), cfg.interceptors...)
+	// innermost, so the other interceptors see the failed call
+	all = append(all, interceptor.NewRecoveryInterceptor(panics.onPanic))
+	for _, i := range all {
+		streamInterceptors = append(streamInterceptors, i.StreamServerInterceptor())
+		unaryInterceptors = append(unaryInterceptors, i.UnaryServerInterceptor())
+	}
+	opts = append(opts,
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
+	)
+	serv := grpc.NewServer(opts...)
+	if cfg.ReflectService {
+		reflection.Register(serv)
+	}
+	if cfg.health != nil {
+		healthpb.RegisterHealthServer(serv, health.NewGrpcServer(cfg.health))
+	}
+	cfg.registerServiceFunc(serv)
+	return &Server{
+		Server:  serv,
+		cfg:     cfg,
+		counter: counter,
+		panics:  panics,
+	}, nil
+}
+
+func RunGrpcServ(ctx context.Context, cfg *GrpcConfig) error {
+	var opts []grpc.ServerOption
+	if tlsCfg := cfg.tlsConfig(); tlsCfg != nil {
+		loaded, err := tlsCfg.ServerConfig()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(loaded.Config)))
+		go func() {
+			err := loaded.Watch(ctx, func(err error) {
+				cfg.Logger.Infof("reload gRPC certificate fail: %v", err)
+			})
+			if err != nil {
+				cfg.Logger.Infof("watch gRPC certificate fail: %v", err)
+			}
+		}()
+	}
+	o, err := cfg.metricsOptions()
+	if err != nil {
+		return err
+	}
+	serv, err := newServer(cfg, o, opts...)
+	if err != nil {
+		return err
 	}
 	port := ":" + strconv.Itoa(cfg.Port)
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		return err
 	}
-	var serv *grpc.Server
-	if len(cfg.interceptors) > 0 {
-		var streamInterceptors []grpc.StreamServerInterceptor
-		var unaryInterceptors []grpc.UnaryServerInterceptor
-		for _, i := range cfg.interceptors {
-			streamInterceptors = append(streamInterceptors, i.StreamServerInterceptor())
-			unaryInterceptors = append(unaryInterceptors, i.UnaryServerInterceptor())
+	errCh := make(chan error, 1)
+	go func(s *grpc.Server, lis net.Listener, l Log) {
+		l.Infof("app gRPC server is running [%s].", lis.Addr())
+		errCh <- s.Serve(lis)
+	}(serv.Server, lis, cfg.Logger)
+	select {
+	case err := <-errCh:
+		if err == nil {
+			return nil
 		}
-		serv = grpc.NewServer(
-			grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
-			grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
-		)
-	} else {
-		serv = grpc.NewServer()
-	}
-	if cfg.ReflectService {
-		reflection.Register(serv)
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
 	}
-	cfg.registerServiceFunc(serv)
-	var grpcWait sync.WaitGroup
-	grpcWait.Add(1)
-	go func(s *grpc.Server, lis net.Listener, l Log) {
-		for {
-			l.Infof("app gRPC server is running [%s].", lis.Addr())
-			if err := s.Serve(lis); err != nil {
-				switch err {
-				case grpc.ErrServerStopped:
-					grpcWait.Done()
-					return
-				default:
-					l.Fatalf("failed to serve: %v", err)
-				}
-			}
+	if cfg.health != nil {
+		// stop the traffic before draining
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.getGracefulTimeout())
+		if err := cfg.health.Shutdown(shutdownCtx); err != nil {
+			cfg.Logger.Infof("app gRPC health shutdown fail: %v", err)
 		}
-	}(serv, lis, cfg.Logger)
-	<-ctx.Done()
-	serv.Stop()
-	grpcWait.Wait()
+		cancel()
+	}
+	serv.GracefulShutdown()
+	if err := <-errCh; err != nil && err != grpc.ErrServerStopped {
+		return err
+	}
+	if n := serv.Panics(); n > 0 {
+		cfg.Logger.Infof("app gRPC server recovered %d panics.", n)
+	}
 	return nil
 }
+
+// Panics returns the number of panics recovered from the service handlers.
+func (s *Server) Panics() int64 {
+	return s.panics.Count()
+}
+
+// Health returns the registry served as grpc.health.v1.Health, nil if none.
+func (s *Server) Health() *health.Registry {
+	return s.cfg.health
+}
+
+// GracefulTimeout is the longest wait for in-flight RPCs at shutdown.
+func (s *Server) GracefulTimeout() time.Duration {
+	return s.cfg.getGracefulTimeout()
+}
+
+const drainPollInterval = 50 * time.Millisecond
+
+// ShutdownServeHTTP is the GracefulShutdown of a server fed through
+// ServeHTTP, whose transports GracefulStop can not drain: it waits for the
+// in-flight RPCs until the graceful timeout and then stops the server,
+// closing the remaining RPCs. Stop the http.Server from accepting new
+// requests first.
+func (s *Server) ShutdownServeHTTP() {
+	timeout := s.cfg.getGracefulTimeout()
+	l := s.cfg.Logger
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for s.counter.Count() > 0 {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			l.Infof("app gRPC server drain timeout [%s], force stop with %d in-flight RPCs.", timeout, s.counter.Count())
+			s.Stop()
+			return
+		}
+	}
+	s.Stop()
+	l.Infof("app gRPC server stopped gracefully.")
+}
+
+// GracefulShutdown waits for in-flight RPCs to finish until the graceful
+// timeout of the config and then stops the server, closing the remaining RPCs.
+func (s *Server) GracefulShutdown() {
+	timeout := s.cfg.getGracefulTimeout()
+	l := s.cfg.Logger
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		l.Infof("app gRPC server stopped gracefully.")
+	case <-timer.C:
+		l.Infof("app gRPC server drain timeout [%s], force stop with %d in-flight RPCs.", timeout, s.counter.Count())
+		s.Stop()
+		<-done
+	}
+}
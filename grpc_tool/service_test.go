@@ -0,0 +1,261 @@
+package grpc_tool
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"github.com/94peter/microservice/health"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type panicHealth struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (panicHealth) Check(context.Context, *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	panic("boom")
+}
+
+func TestServerRecovery(t *testing.T) {
+	cfg := &GrpcConfig{Logger: testLog{}}
+	cfg.SetRegisterServiceFunc(func(s *grpc.Server) {
+		healthpb.RegisterHealthServer(s, panicHealth{})
+	})
+	panics := make(chan string, 1)
+	cfg.OnPanic(func(ctx context.Context, method string, recovered any, stack []byte) {
+		panics <- method
+	})
+	// a server built from the same config must not add its handler to it
+	var local atomic.Int64
+	for i := 0; i < 2; i++ {
+		if _, err := NewServer(cfg, WithPanicHandler(func(context.Context, string, any, []byte) {
+			t.Error("handler of another server called")
+		})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serv, err := NewServer(cfg, WithPanicHandler(func(context.Context, string, any, []byte) {
+		local.Add(1)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewConnection(ctx, lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+	for i := 0; i < 2; i++ {
+		_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if status.Code(err) != codes.Internal {
+			t.Fatalf("expected Internal, got %v", err)
+		}
+		if method := <-panics; method != "/grpc.health.v1.Health/Check" {
+			t.Errorf("unexpected method %s", method)
+		}
+	}
+	if len(panics) != 0 || local.Load() != 2 || serv.Panics() != 2 {
+		t.Errorf("expected 2 panics, got %d more, %d local and %d counted", len(panics), local.Load(), serv.Panics())
+	}
+}
+
+type recordLog struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordLog) Infof(format string, a ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, a...))
+}
+
+func (l *recordLog) Fatalf(format string, a ...any) {
+	l.Infof(format, a...)
+}
+
+func (l *recordLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.msgs, "\n")
+}
+
+func TestGracefulShutdownForceStop(t *testing.T) {
+	l := &recordLog{}
+	cfg := &GrpcConfig{GracefulTimeout: 200 * time.Millisecond, Logger: l}
+	cfg.SetHealth(health.NewRegistry())
+	cfg.SetRegisterServiceFunc(func(*grpc.Server) {})
+	serv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewConnection(ctx, lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a watch never ends by itself
+	stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if n := serv.counter.Count(); n != 1 {
+		t.Fatalf("expected 1 in-flight RPC, got %d", n)
+	}
+
+	start := time.Now()
+	serv.GracefulShutdown()
+	if d := time.Since(start); d < cfg.GracefulTimeout || d > 2*time.Second {
+		t.Errorf("unexpected shutdown time %s", d)
+	}
+	if !strings.Contains(l.String(), "drain timeout [200ms], force stop with 1 in-flight RPCs.") {
+		t.Errorf("unexpected log:\n%s", l)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+}
+
+func TestRunGrpcServFlipsReadiness(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+
+	reg := health.NewRegistry()
+	reg.DrainDelay = 0
+	cfg := &GrpcConfig{Port: port, Logger: testLog{}}
+	cfg.SetHealth(reg)
+	cfg.SetRegisterServiceFunc(func(*grpc.Server) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := RunGrpcServ(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !reg.IsShuttingDown() {
+		t.Error("expected readiness to flip")
+	}
+}
+
+func TestInterceptorRecovery(t *testing.T) {
+	cfg := &GrpcConfig{Logger: testLog{}}
+	cfg.SetHealth(health.NewRegistry())
+	cfg.SetRegisterServiceFunc(func(*grpc.Server) {})
+	cfg.SetInterceptors(interceptor.NewSimpleInterceptor(
+		func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			panic("stream interceptor")
+		},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			panic("unary interceptor")
+		},
+	))
+	serv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewConnection(ctx, lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal, got %v", err)
+	}
+	if n := serv.Panics(); n != 1 {
+		t.Errorf("expected 1 panic, got %d", n)
+	}
+}
+
+func TestRunGrpcServPanicMetric(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	reg := prometheus.NewRegistry()
+	cfg := &GrpcConfig{Port: lis.Addr().(*net.TCPAddr).Port, Logger: testLog{}}
+	cfg.SetRegisterServiceFunc(func(s *grpc.Server) {
+		healthpb.RegisterHealthServer(s, panicHealth{})
+	})
+	cfg.SetMetrics("test", reg)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- RunGrpcServ(ctx, cfg)
+	}()
+
+	dialCtx, dialCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer dialCancel()
+	conn, err := NewConnection(dialCtx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(dialCtx, &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Internal {
+		t.Fatalf("expected Internal, got %v", err)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counted float64
+	for _, f := range families {
+		if f.GetName() == "panics_total" {
+			counted = f.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	if counted != 1 {
+		t.Errorf("expected 1 panic counted, got %v", counted)
+	}
+}
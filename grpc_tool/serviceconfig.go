@@ -0,0 +1,71 @@
+package grpc_tool
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/balancer/leastrequest"
+	"google.golang.org/grpc/balancer/roundrobin"
+)
+
+const (
+	BalancerPickFirst    = "pick_first"
+	BalancerRoundRobin   = "round_robin"
+	BalancerLeastRequest = "least_request"
+)
+
+var balancerNames = map[string]string{
+	BalancerPickFirst:    BalancerPickFirst,
+	BalancerRoundRobin:   roundrobin.Name,
+	BalancerLeastRequest: leastrequest.Name,
+}
+
+// serviceConfig is the JSON form of the gRPC service config.
+type serviceConfig struct {
+	LoadBalancingConfig []map[string]any `json:"loadBalancingConfig,omitempty"`
+	MethodConfig        []methodConfig   `json:"methodConfig,omitempty"`
+}
+
+type methodConfig struct {
+	Name        []methodName     `json:"name"`
+	Timeout     string           `json:"timeout,omitempty"`
+	RetryPolicy *retryPolicyJSON `json:"retryPolicy,omitempty"`
+}
+
+// methodName selects every method when both fields are empty.
+type methodName struct {
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+}
+
+// serviceConfig returns the default service config of the connection, with
+// methods taking precedence over the connection wide retry policy.
+func (o *connOptions) serviceConfig(methods []methodConfig) (string, error) {
+	var sc serviceConfig
+	if o.balancer != "" {
+		name, ok := balancerNames[o.balancer]
+		if !ok {
+			return "", fmt.Errorf("unknown balancer [%s]", o.balancer)
+		}
+		sc.LoadBalancingConfig = []map[string]any{{name: struct{}{}}}
+	}
+	if o.retry != nil {
+		retry, err := o.retry.toJSON()
+		if err != nil {
+			return "", err
+		}
+		sc.MethodConfig = append(sc.MethodConfig, methodConfig{
+			Name:        []methodName{{}},
+			RetryPolicy: retry,
+		})
+	}
+	sc.MethodConfig = append(sc.MethodConfig, methods...)
+	if sc.LoadBalancingConfig == nil && sc.MethodConfig == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,32 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+func (r *Registry) LivenessHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writeResult(c, r.Live(c.Request.Context()))
+	}
+}
+
+func (r *Registry) ReadinessHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writeResult(c, r.Ready(c.Request.Context()))
+	}
+}
+
+func writeResult(c *gin.Context, result Result) {
+	if result.Healthy {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+	c.JSON(http.StatusServiceUnavailable, result)
+}
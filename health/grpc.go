@@ -0,0 +1,83 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// LivenessService is the grpc.health.v1 service name answering liveness.
+	// The empty service name answers overall readiness, any other name checks
+	// the readiness checker registered with that name.
+	LivenessService = "liveness"
+
+	watchInterval = 5 * time.Second
+)
+
+func NewGrpcServer(r *Registry) healthpb.HealthServer {
+	return &grpcServer{registry: r}
+}
+
+type grpcServer struct {
+	healthpb.UnimplementedHealthServer
+	registry *Registry
+}
+
+func (s *grpcServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, err := s.status(ctx, req.GetService())
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+func (s *grpcServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	shutdown := s.registry.shutdownCh
+	for {
+		st, err := s.status(ctx, req.GetService())
+		if err != nil {
+			st = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-shutdown:
+			shutdown = nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *grpcServer) status(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	var healthy bool
+	switch service {
+	case "":
+		healthy = s.registry.Ready(ctx).Healthy
+	case LivenessService:
+		healthy = s.registry.Live(ctx).Healthy
+	default:
+		var err error
+		healthy, err = s.registry.Check(ctx, service)
+		if err != nil {
+			return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, status.Error(codes.NotFound, err.Error())
+		}
+	}
+	if healthy {
+		return healthpb.HealthCheckResponse_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING, nil
+}
@@ -0,0 +1,191 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCheckTimeout = 3 * time.Second
+	defaultDrainDelay   = 5 * time.Second
+
+	drainDelayEnv = "HEALTH_DRAIN_DELAY"
+)
+
+// Checker reports an error when the checked component is unhealthy.
+type Checker func(ctx context.Context) error
+
+// Checkable is implemented by components that know how to check themselves,
+// e.g. a DI holding database connections.
+type Checkable interface {
+	HealthCheck(ctx context.Context) error
+}
+
+type Registry struct {
+	CheckTimeout time.Duration
+	// DrainDelay is how long Shutdown keeps the process serving after
+	// readiness flipped, so load balancers can stop sending traffic. Zero
+	// flips readiness without waiting.
+	DrainDelay time.Duration
+
+	mu        sync.RWMutex
+	liveness  []namedChecker
+	readiness []namedChecker
+
+	shuttingDown atomic.Bool
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	shutdownAt   time.Time
+}
+
+type namedChecker struct {
+	name    string
+	checker Checker
+}
+
+// NewRegistry returns a registry with the DrainDelay of DrainDelayFromEnv.
+func NewRegistry() *Registry {
+	return &Registry{
+		DrainDelay: DrainDelayFromEnv(),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+// DrainDelayFromEnv returns the duration HEALTH_DRAIN_DELAY sets, e.g. "0s"
+// to disable it, or 5s when it is blank or invalid.
+func DrainDelayFromEnv() time.Duration {
+	d, err := time.ParseDuration(os.Getenv(drainDelayEnv))
+	if err != nil || d < 0 {
+		return defaultDrainDelay
+	}
+	return d
+}
+
+// Register adds a readiness checker.
+func (r *Registry) Register(name string, c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readiness = append(r.readiness, namedChecker{name: name, checker: c})
+}
+
+// RegisterLiveness adds a liveness checker. A failing liveness check means
+// the process should be restarted.
+func (r *Registry) RegisterLiveness(name string, c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveness = append(r.liveness, namedChecker{name: name, checker: c})
+}
+
+// Shutdown flips readiness to not serving and waits until DrainDelay passed
+// since its first call, so the servers sharing the registry wait only once.
+// It is meant to be called at the start of shutdown, before the listeners
+// close.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.shutdownOnce.Do(func() {
+		r.shutdownAt = time.Now()
+		r.shuttingDown.Store(true)
+		close(r.shutdownCh)
+	})
+	wait := time.Until(r.shutdownAt.Add(r.DrainDelay))
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Registry) IsShuttingDown() bool {
+	return r.shuttingDown.Load()
+}
+
+// Result maps checker names to their error message, empty when healthy.
+type Result struct {
+	Healthy bool              `json:"healthy"`
+	Checks  map[string]string `json:"checks,omitempty"`
+}
+
+func (r *Registry) Live(ctx context.Context) Result {
+	r.mu.RLock()
+	checkers := r.liveness
+	r.mu.RUnlock()
+	return r.run(ctx, checkers)
+}
+
+func (r *Registry) Ready(ctx context.Context) Result {
+	if r.IsShuttingDown() {
+		return Result{Healthy: false, Checks: map[string]string{"shutdown": "service is shutting down"}}
+	}
+	r.mu.RLock()
+	checkers := r.readiness
+	r.mu.RUnlock()
+	return r.run(ctx, checkers)
+}
+
+// Check runs a single readiness checker by name.
+func (r *Registry) Check(ctx context.Context, name string) (bool, error) {
+	r.mu.RLock()
+	var checker Checker
+	for _, c := range r.readiness {
+		if c.name == name {
+			checker = c.checker
+			break
+		}
+	}
+	r.mu.RUnlock()
+	if checker == nil {
+		return false, fmt.Errorf("checker [%s] not found", name)
+	}
+	if r.IsShuttingDown() {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.getCheckTimeout())
+	defer cancel()
+	return checker(ctx) == nil, nil
+}
+
+func (r *Registry) run(ctx context.Context, checkers []namedChecker) Result {
+	result := Result{Healthy: true, Checks: make(map[string]string, len(checkers))}
+	if len(checkers) == 0 {
+		return result
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.getCheckTimeout())
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checkers {
+		wg.Add(1)
+		go func(c namedChecker) {
+			defer wg.Done()
+			msg := ""
+			if err := c.checker(ctx); err != nil {
+				msg = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			result.Checks[c.name] = msg
+			if msg != "" {
+				result.Healthy = false
+			}
+		}(c)
+	}
+	wg.Wait()
+	return result
+}
+
+func (r *Registry) getCheckTimeout() time.Duration {
+	if r.CheckTimeout <= 0 {
+		return defaultCheckTimeout
+	}
+	return r.CheckTimeout
+}
@@ -0,0 +1,77 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/94peter/microservice/health"
+)
+
+func TestReady(t *testing.T) {
+	reg := health.NewRegistry()
+	reg.Register("db", func(ctx context.Context) error { return nil })
+	if result := reg.Ready(context.Background()); !result.Healthy {
+		t.Errorf("expected healthy, got %v", result.Checks)
+	}
+
+	reg.Register("cache", func(ctx context.Context) error { return errors.New("connection refused") })
+	result := reg.Ready(context.Background())
+	if result.Healthy {
+		t.Error("expected unhealthy when a checker fails")
+	}
+	if result.Checks["cache"] != "connection refused" || result.Checks["db"] != "" {
+		t.Errorf("unexpected checks: %v", result.Checks)
+	}
+	if ok, err := reg.Check(context.Background(), "db"); err != nil || !ok {
+		t.Errorf("expected db to be healthy, got %v, %v", ok, err)
+	}
+	if _, err := reg.Check(context.Background(), "unknown"); err == nil {
+		t.Error("expected error for unknown checker")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	reg := health.NewRegistry()
+	reg.DrainDelay = 200 * time.Millisecond
+	start := time.Now()
+	if err := reg.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < reg.DrainDelay {
+		t.Errorf("expected to wait the drain delay, waited %s", d)
+	}
+	// a second server sharing the registry does not wait again
+	start = time.Now()
+	if err := reg.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d >= reg.DrainDelay {
+		t.Errorf("expected no second wait, waited %s", d)
+	}
+	if reg.Ready(context.Background()).Healthy {
+		t.Error("expected not ready after shutdown")
+	}
+	if !reg.Live(context.Background()).Healthy {
+		t.Error("expected still alive after shutdown")
+	}
+}
+
+func TestDrainDelayFromEnv(t *testing.T) {
+	tests := []struct {
+		env  string
+		want time.Duration
+	}{
+		{"", 5 * time.Second},
+		{"0s", 0},
+		{"12s", 12 * time.Second},
+		{"abc", 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Setenv("HEALTH_DRAIN_DELAY", tt.env)
+		if got := health.NewRegistry().DrainDelay; got != tt.want {
+			t.Errorf("HEALTH_DRAIN_DELAY=%q: expected %s, got %s", tt.env, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package filewatch
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const debounce = 100 * time.Millisecond
+
+// Watch calls onChange whenever one of files is written, created, renamed or
+// removed, until ctx is done. The parent directories are watched instead of
+// the files themselves so that atomic replaces and Kubernetes volume symlink
+// swaps are noticed as well.
+func Watch(ctx context.Context, files []string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	targets := make(map[string]bool, len(files))
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		f = filepath.Clean(f)
+		targets[f] = true
+		dir := filepath.Dir(f)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+		dirs[dir] = true
+	}
+
+	var timer *time.Timer
+	var fire <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Kubernetes swaps the "..data" symlink when a mounted volume changes.
+			if !targets[filepath.Clean(event.Name)] && filepath.Base(event.Name) != "..data" {
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(debounce)
+			} else {
+				timer.Reset(debounce)
+			}
+			fire = timer.C
+		case <-fire:
+			fire = nil
+			onChange()
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultStopTimeout = 10 * time.Second
+
+type Hook func(ctx context.Context) error
+
+// Component is a unit of the service with start, ready and stop hooks.
+// Start must return once the component is started, Ready blocks until it
+// can serve, both within StartTimeout, and Stop is called in reverse start
+// order within StopTimeout.
+//
+// Run, if set, is called in its own goroutine after Start and must block
+// until its context is cancelled. A Run error cancels every other component
+// and is returned from Manager.Run.
+type Component struct {
+	Name      string
+	DependsOn []string
+
+	Start Hook
+	Run   Hook
+	Ready Hook
+	Stop  Hook
+
+	StartTimeout time.Duration
+	StopTimeout  time.Duration
+}
+
+type running struct {
+	*Component
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type Manager struct {
+	components []*Component
+	names      map[string]*Component
+
+	onShutdown []Hook
+
+	mu      sync.Mutex
+	started []*running
+	errs    []error
+}
+
+func New() *Manager {
+	return &Manager{
+		names: make(map[string]*Component),
+	}
+}
+
+func (m *Manager) Add(cs ...*Component) error {
+	for _, c := range cs {
+		if c.Name == "" {
+			return errors.New("component name must not be empty")
+		}
+		if _, ok := m.names[c.Name]; ok {
+			return fmt.Errorf("component [%s] already exists", c.Name)
+		}
+		m.names[c.Name] = c
+		m.components = append(m.components, c)
+	}
+	return nil
+}
+
+// OnShutdown adds hooks that are called once shutdown begins, before any
+// component is stopped, e.g. to flip readiness so traffic drains first.
+func (m *Manager) OnShutdown(hooks ...Hook) {
+	m.onShutdown = append(m.onShutdown, hooks...)
+}
+
+// Run starts components in dependency order, waiting for each to be ready,
+// and stops them in reverse order once ctx is done or a component fails.
+// The returned error aggregates every start, run and stop failure.
+func (m *Manager) Run(ctx context.Context) error {
+	order, err := m.order()
+	if err != nil {
+		return err
+	}
+	runCtx, fail := context.WithCancel(ctx)
+	defer fail()
+	for _, c := range order {
+		if runCtx.Err() != nil {
+			// shutdown was requested while starting
+			break
+		}
+		if err := m.start(runCtx, c, fail); err != nil {
+			m.addErr(err)
+			fail()
+			break
+		}
+	}
+	<-runCtx.Done()
+	m.shutdown()
+	m.stop()
+	return m.result()
+}
+
+func (m *Manager) start(ctx context.Context, c *Component, fail context.CancelFunc) error {
+	if c.StartTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.StartTimeout)
+		defer cancel()
+	}
+	if c.Start != nil {
+		if err := c.Start(ctx); err != nil {
+			return fmt.Errorf("start component [%s] fail: %w", c.Name, err)
+		}
+	}
+	r := &running{Component: c}
+	if c.Run != nil {
+		var runCtx context.Context
+		runCtx, r.cancel = context.WithCancel(context.Background())
+		r.done = make(chan struct{})
+		go func() {
+			defer close(r.done)
+			if err := c.Run(runCtx); err != nil && !(runCtx.Err() != nil && errors.Is(err, context.Canceled)) {
+				m.addErr(fmt.Errorf("component [%s] fail: %w", c.Name, err))
+				fail()
+			}
+		}()
+	}
+	m.mu.Lock()
+	m.started = append(m.started, r)
+	m.mu.Unlock()
+	if c.Ready == nil {
+		return nil
+	}
+	if err := c.Ready(ctx); err != nil {
+		return fmt.Errorf("component [%s] not ready: %w", c.Name, err)
+	}
+	return nil
+}
+
+func (m *Manager) shutdown() {
+	for _, h := range m.onShutdown {
+		if err := h(context.Background()); err != nil {
+			m.addErr(fmt.Errorf("shutdown hook fail: %w", err))
+		}
+	}
+}
+
+func (m *Manager) stop() {
+	m.mu.Lock()
+	started := m.started
+	m.started = nil
+	m.mu.Unlock()
+
+	for i := len(started) - 1; i >= 0; i-- {
+		r := started[i]
+		timeout := r.StopTimeout
+		if timeout <= 0 {
+			timeout = defaultStopTimeout
+		}
+		if err := stopWithTimeout(r, timeout); err != nil {
+			m.addErr(fmt.Errorf("stop component [%s] fail: %w", r.Name, err))
+		}
+	}
+}
+
+func stopWithTimeout(r *running, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if r.Stop != nil {
+			err = r.Stop(ctx)
+		}
+		if r.cancel != nil {
+			r.cancel()
+			<-r.done
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *Manager) addErr(err error) {
+	m.mu.Lock()
+	m.errs = append(m.errs, err)
+	m.mu.Unlock()
+}
+
+func (m *Manager) result() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := errors.Join(m.errs...)
+	m.errs = nil
+	return err
+}
+
+// order returns components sorted topologically, keeping insertion order
+// for independent components.
+func (m *Manager) order() ([]*Component, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(m.components))
+	result := make([]*Component, 0, len(m.components))
+	var visit func(c *Component, path []string) error
+	visit = func(c *Component, path []string) error {
+		switch state[c.Name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle: %v", append(path, c.Name))
+		}
+		state[c.Name] = visiting
+		for _, d := range c.DependsOn {
+			dep, ok := m.names[d]
+			if !ok {
+				return fmt.Errorf("component [%s] depends on unknown component [%s]", c.Name, d)
+			}
+			if err := visit(dep, append(path, c.Name)); err != nil {
+				return err
+			}
+		}
+		state[c.Name] = visited
+		result = append(result, c)
+		return nil
+	}
+	for _, c := range m.components {
+		if err := visit(c, nil); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,151 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/94peter/microservice/lifecycle"
+)
+
+func newRecorder(events *[]string, name string, deps ...string) *lifecycle.Component {
+	return &lifecycle.Component{
+		Name:      name,
+		DependsOn: deps,
+		Start: func(ctx context.Context) error {
+			*events = append(*events, "start "+name)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			*events = append(*events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestRunOrder(t *testing.T) {
+	var events []string
+	mgr := lifecycle.New()
+	err := mgr.Add(
+		newRecorder(&events, "grpc", "db"),
+		newRecorder(&events, "db"),
+		newRecorder(&events, "api", "grpc"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := mgr.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// ctx is already cancelled, so nothing must be started
+	if len(events) != 0 {
+		t.Errorf("expected no events, got %v", events)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := mgr.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expected := "start db,start grpc,start api,stop api,stop grpc,stop db"
+	if got := strings.Join(events, ","); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestRunCycle(t *testing.T) {
+	var events []string
+	mgr := lifecycle.New()
+	_ = mgr.Add(
+		newRecorder(&events, "a", "b"),
+		newRecorder(&events, "b", "a"),
+	)
+	err := mgr.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+		t.Errorf("expected dependency cycle error, got %v", err)
+	}
+}
+
+func TestRunNotReady(t *testing.T) {
+	var events []string
+	db := newRecorder(&events, "db")
+	db.StartTimeout = 10 * time.Millisecond
+	db.Ready = func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	mgr := lifecycle.New()
+	_ = mgr.Add(db, newRecorder(&events, "api", "db"))
+	err := mgr.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Errorf("expected not ready error, got %v", err)
+	}
+	expected := "start db,stop db"
+	if got := strings.Join(events, ","); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestStartTimeout(t *testing.T) {
+	mgr := lifecycle.New()
+	_ = mgr.Add(&lifecycle.Component{
+		Name:         "slow",
+		StartTimeout: 10 * time.Millisecond,
+		Start: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	err := mgr.Run(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "start component [slow] fail") {
+		t.Errorf("expected start timeout, got %v", err)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	mgr := lifecycle.New()
+	_ = mgr.Add(&lifecycle.Component{
+		Name:        "slow",
+		StopTimeout: 10 * time.Millisecond,
+		Stop: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	err := mgr.Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("expected deadline exceeded error, got %v", err)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	var events []string
+	api := newRecorder(&events, "api")
+	api.Run = func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	grpc := newRecorder(&events, "grpc")
+	grpc.Run = func(ctx context.Context) error {
+		return errors.New("bind fail")
+	}
+	mgr := lifecycle.New()
+	_ = mgr.Add(api, grpc)
+	err := mgr.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "bind fail") {
+		t.Errorf("expected bind fail error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "canceled") {
+		t.Errorf("expected cancelled component to be ignored, got %v", err)
+	}
+	expected := "start api,start grpc,stop grpc,stop api"
+	if got := strings.Join(events, ","); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
@@ -0,0 +1,58 @@
+package microservice
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/94peter/microservice/grpc_tool"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// NewMixServiceWithViper serves the gin api and the gRPC services of grpcCfg
+// on the api port. gRPC requests are told apart by their content type; without
+// TLS, HTTP/2 is accepted in cleartext (h2c). The DI and model config set by
+// WithServiceDI are injected into gRPC calls as well.
+func NewMixServiceWithViper(grpcCfg *grpc_tool.GrpcConfig, opts ...options) (ServiceHandler, error) {
+	serv, servCfg, err := newGinServWithViper(opts...)
+	if err != nil {
+		return nil, err
+	}
+	grpcServ, err := serv.newGrpcServer(grpcCfg, true)
+	if err != nil {
+		serv.close()
+		return nil, err
+	}
+
+	if reg := grpcServ.Health(); reg != nil {
+		serv.shutdownHooks = append(serv.shutdownHooks, reg.Shutdown)
+	}
+	handler := mixHandler(grpcServ, serv.Engine)
+	if !servCfg.isTLS() {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	httpServ, reloader, err := servCfg.newServer(handler)
+	if err != nil {
+		serv.close()
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		defer serv.close()
+		log.Println("start mix service addr:", httpServ.Addr)
+		// GracefulStop panics on the transports of ServeHTTP
+		return serv.runApiService(ctx, httpServ, reloader, grpcServ.ShutdownServeHTTP, grpcServ.GracefulTimeout())
+	}, nil
+}
+
+func mixHandler(grpcServ http.Handler, httpHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServ.ServeHTTP(w, r)
+			return
+		}
+		httpHandler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,150 @@
+package microservice
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/94peter/microservice/grpc_tool"
+	"github.com/94peter/microservice/health"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testLog struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *testLog) Infof(format string, a ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, a...))
+}
+
+func (l *testLog) Fatalf(format string, a ...any) {
+	l.Infof(format, a...)
+}
+
+func (l *testLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.msgs, "\n")
+}
+
+func TestMixHandler(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		})
+	}
+	handler := mixHandler(named("grpc"), named("http"))
+	tests := []struct {
+		name        string
+		protoMajor  int
+		contentType string
+		want        string
+	}{
+		{"grpc over http2", 2, "application/grpc", "grpc"},
+		{"grpc with codec", 2, "application/grpc+proto", "grpc"},
+		{"grpc content type over http1", 1, "application/grpc", "http"},
+		{"json over http2", 2, "application/json", "http"},
+		{"json over http1", 1, "application/json", "http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/svc/Method", nil)
+			r.ProtoMajor = tt.protoMajor
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+func TestMixServiceShutdownWithOpenStream(t *testing.T) {
+	port := freePort(t)
+	viper.Set("service", "test")
+	viper.Set("api.host", "127.0.0.1")
+	viper.Set("api.port", port)
+	defer viper.Reset()
+
+	l := &testLog{}
+	reg := health.NewRegistry()
+	reg.DrainDelay = 0
+	grpcCfg := &grpc_tool.GrpcConfig{GracefulTimeout: 300 * time.Millisecond, Logger: l}
+	grpcCfg.SetHealth(reg)
+	grpcCfg.SetRegisterServiceFunc(func(*grpc.Server) {})
+	handler, err := NewMixServiceWithViper(grpcCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- handler(ctx)
+	}()
+
+	conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var stream healthpb.Health_WatchClient
+	// the listener may not be up yet
+	for i := 0; i < 50; i++ {
+		stream, err = healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		if err == nil {
+			if _, err = stream.Recv(); err == nil {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mix service did not stop")
+	}
+	if !reg.IsShuttingDown() {
+		t.Error("expected readiness to flip")
+	}
+	if !strings.Contains(l.String(), "force stop with 1 in-flight RPCs") {
+		t.Errorf("open stream not force stopped:\n%s", l)
+	}
+	for {
+		if _, err := stream.Recv(); err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/94peter/microservice/grpc_tool/interceptor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func fromIncoming(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(MetadataKey); len(v) > 0 {
+		return orNew(v[0])
+	}
+	return New()
+}
+
+// Interceptor takes the request id from the x-request-id metadata or
+// generates one, stores it in the call context and echoes it in the
+// response header.
+func Interceptor() interceptor.Interceptor {
+	return interceptor.NewSimpleInterceptor(
+		func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			id := fromIncoming(ss.Context())
+			_ = ss.SetHeader(metadata.Pairs(MetadataKey, id))
+			return handler(srv, interceptor.NewServerStream(NewContext(ss.Context(), id), ss))
+		},
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			id := fromIncoming(ctx)
+			_ = grpc.SetHeader(ctx, metadata.Pairs(MetadataKey, id))
+			return handler(NewContext(ctx, id), req)
+		},
+	)
+}
+
+// ClientInterceptor sends the request id of the call context, if any, in the
+// x-request-id metadata.
+func ClientInterceptor() interceptor.ClientInterceptor {
+	outgoing := func(ctx context.Context) context.Context {
+		id := FromContext(ctx)
+		if id == "" {
+			return ctx
+		}
+		if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(MetadataKey)) > 0 {
+			return ctx
+		}
+		return metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+	}
+	return interceptor.NewSimpleClientInterceptor(
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(outgoing(ctx), desc, cc, method, opts...)
+		},
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+		},
+	)
+}
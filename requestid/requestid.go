@@ -0,0 +1,69 @@
+// Package requestid carries the id of a request through HTTP, gRPC and the
+// outgoing gRPC calls made while handling it.
+package requestid
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	Header      = "X-Request-ID"
+	MetadataKey = "x-request-id"
+
+	maxLength = 128
+)
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request id of ctx, empty when there is none.
+func FromContext(ctx context.Context) string {
+	if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+func New() string {
+	return uuid.New().String()
+}
+
+// valid accepts ids of printable ASCII without spaces, so a client can not
+// inject arbitrary content into logs and headers.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// orNew returns id when valid, a new id otherwise.
+func orNew(id string) string {
+	if valid(id) {
+		return id
+	}
+	return New()
+}
+
+// GinHandler takes the request id from the X-Request-ID header or generates
+// one, stores it in the request context and echoes it in the response.
+func GinHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := orNew(c.GetHeader(Header))
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+		c.Header(Header, id)
+		c.Next()
+	}
+}
@@ -0,0 +1,55 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestGinHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GinHandler())
+	var got string
+	r.GET("/", func(c *gin.Context) { got = FromContext(c) })
+
+	for header, keep := range map[string]bool{"abc-123": true, "bad id\n": false, "": false} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(Header, header)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if keep && got != header {
+			t.Errorf("expected id [%s], got [%s]", header, got)
+		}
+		if !keep && (got == header || got == "") {
+			t.Errorf("expected a new id for [%s], got [%s]", header, got)
+		}
+		if echo := w.Header().Get(Header); echo != got {
+			t.Errorf("expected echoed id [%s], got [%s]", got, echo)
+		}
+	}
+}
+
+func TestGrpcPropagation(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "abc-123"))
+	var outgoing metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		outgoing, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, ClientInterceptor().UnaryClientInterceptor()(ctx, "/svc/Method", nil, nil, nil, invoker)
+	}
+	_, err := Interceptor().UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := outgoing.Get(MetadataKey); len(v) != 1 || v[0] != "abc-123" {
+		t.Errorf("request id not forwarded: %v", outgoing)
+	}
+}
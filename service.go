@@ -2,30 +2,46 @@ package microservice
 
 import (
 	"context"
+	"fmt"
+	stdlog "log"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
+	"time"
 
 	"github.com/94peter/log"
 	"github.com/94peter/microservice/cfg"
 	"github.com/94peter/microservice/di"
+	"github.com/94peter/microservice/grpc_tool"
+	"github.com/94peter/microservice/health"
+	"github.com/94peter/microservice/lifecycle"
+	"github.com/94peter/microservice/tracing"
 )
 
 type MicroService[T cfg.ModelCfg, R di.ServiceDI] interface {
 	GetModelCfgMgr() cfg.ModelCfgMgr
 	GetDI() R
+	// GetDIReloader returns the reloader of the DI. Pass it to WithServiceDI
+	// so that requests get the DI loaded last.
+	GetDIReloader() *di.Reloader[R]
+	// WatchDI reloads the DI when its config files change until ctx is done.
+	WatchDI(ctx context.Context) error
 	NewLog(name string) (log.Logger, error)
+	NewLogWithCtx(ctx context.Context, name string) (log.Logger, error)
 	NewCfg(name string) (T, error)
+	GetHealth() *health.Registry
 }
 
-type ServiceHandler func(ctx context.Context)
+// ServiceHandler runs until ctx is cancelled. A returned error stops every
+// other handler started by RunService.
+type ServiceHandler func(ctx context.Context) error
 
 type microService[T cfg.ModelCfg, R di.ServiceDI] struct {
 	Cfg T
-	DI  R
+	DI  *di.Reloader[R]
 
 	cfgMgr cfg.ModelCfgMgr
+	health *health.Registry
 }
 
 func New[T cfg.ModelCfg, R di.ServiceDI](mycfg T, mydi R) (MicroService[T, R], error) {
@@ -40,10 +56,15 @@ func New[T cfg.ModelCfg, R di.ServiceDI](mycfg T, mydi R) (MicroService[T, R], e
 	if err = mydi.IsConfEmpty(); err != nil {
 		return nil, err
 	}
+	reloader := di.NewServiceDIReloader(diCfg, mydi)
+	reg := health.NewRegistry()
+	reg.Register("di", di.HealthChecker(reloader))
+	reg.Register("model_cfg", cfg.HealthChecker(mycfg, reloader))
 	return &microService[T, R]{
 		Cfg:    mycfg,
-		DI:     mydi,
+		DI:     reloader,
 		cfgMgr: cfg.NewFixModelCfgGinMid(mycfg),
+		health: reg,
 	}, nil
 }
 
@@ -51,13 +72,34 @@ func (s *microService[T, R]) GetModelCfgMgr() cfg.ModelCfgMgr {
 	return s.cfgMgr
 }
 
+// GetDI returns the DI loaded last.
 func (s *microService[T, R]) GetDI() R {
+	return s.DI.Get()
+}
+
+func (s *microService[T, R]) GetDIReloader() *di.Reloader[R] {
 	return s.DI
 }
 
+// WatchDI is a ServiceHandler. A config that fails to load or IsConfEmpty
+// is logged and the previous DI is kept.
+func (s *microService[T, R]) WatchDI(ctx context.Context) error {
+	return s.DI.Watch(ctx, func(err error) {
+		stdlog.Println("reload di fail:", err)
+	})
+}
+
+// GetHealth returns the health registry with the DI and model config checkers
+// registered. The api and gRPC handlers serving it flip readiness before
+// their listeners close; call its Shutdown from lifecycle.Manager.OnShutdown
+// to flip it before any component stops.
+func (s *microService[T, R]) GetHealth() *health.Registry {
+	return s.health
+}
+
 func (s *microService[T, R]) NewCfg(name string) (T, error) {
 	mycfg := s.Cfg.Copy()
-	err := mycfg.Init(name, s.DI)
+	err := mycfg.Init(name, s.GetDI())
 	if err != nil {
 		return mycfg.(T), err
 	}
@@ -65,29 +107,76 @@ func (s *microService[T, R]) NewCfg(name string) (T, error) {
 }
 
 func (s *microService[T, R]) NewLog(name string) (log.Logger, error) {
-	return s.DI.NewLogger(s.DI.GetService(), name)
+	servDI := s.GetDI()
+	return servDI.NewLogger(servDI.GetService(), name)
 }
 
-func RunService(ss ...ServiceHandler) {
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-	signal.Notify(sig, syscall.SIGTERM)
-	var wg sync.WaitGroup
-	var ctxCancelSlice = make([]context.CancelFunc, len(ss))
-	for i, s := range ss {
-		ctx, ctxCancel := context.WithCancel(context.Background())
-		ctxCancelSlice[i] = ctxCancel
-		wg.Add(1)
-		go func(ctx context.Context, handler ServiceHandler) {
-			defer wg.Done()
-			handler(ctx)
-		}(ctx, s)
+// NewLogWithCtx returns the logger of NewLog with the trace id of the span
+// in ctx attached to its messages.
+func (s *microService[T, R]) NewLogWithCtx(ctx context.Context, name string) (log.Logger, error) {
+	l, err := s.NewLog(name)
+	if err != nil {
+		return nil, err
 	}
-	<-sig
-	for _, cancel := range ctxCancelSlice {
-		if cancel != nil {
-			cancel()
+	return tracing.NewLogger(ctx, l), nil
+}
+
+// RunService starts every handler, waits for SIGINT/SIGTERM or the first
+// handler failure and stops the handlers in reverse order. The returned
+// error aggregates every handler failure.
+func RunService(ss ...ServiceHandler) error {
+	return RunServiceWithStopTimeout(0, ss...)
+}
+
+// RunServiceWithStopTimeout is RunService giving each handler timeout to
+// stop, or HandlerStopTimeout when timeout is not positive.
+func RunServiceWithStopTimeout(timeout time.Duration, ss ...ServiceHandler) error {
+	mgr := lifecycle.New()
+	for i, s := range ss {
+		c := HandlerComponent(fmt.Sprintf("service-%d", i), s)
+		if timeout > 0 {
+			c.StopTimeout = timeout
+		}
+		if err := mgr.Add(c); err != nil {
+			return err
 		}
 	}
-	wg.Wait()
+	return RunLifecycle(mgr)
+}
+
+// RunServiceOrExit calls RunService and exits the process with a non-zero
+// status when it fails.
+func RunServiceOrExit(ss ...ServiceHandler) {
+	if err := RunService(ss...); err != nil {
+		stdlog.Println("service fail:", err)
+		os.Exit(1)
+	}
+}
+
+// RunLifecycle runs mgr until SIGINT/SIGTERM is received or a component fails.
+func RunLifecycle(mgr *lifecycle.Manager) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return mgr.Run(ctx)
+}
+
+const handlerStopMargin = 5 * time.Second
+
+// HandlerStopTimeout is the time a handler gets to stop: the readiness drain
+// delay WithHealth uses, the gRPC drain timeout of GRPC_GRACEFUL_TIMEOUT and
+// the shutdown of the api, so the drain ends with its forced stop before the
+// handler is given up.
+func HandlerStopTimeout() time.Duration {
+	return getDrainDelay() + grpc_tool.GracefulTimeoutFromEnv() + apiShutdownTimeout + handlerStopMargin
+}
+
+// HandlerComponent wraps a long running handler as a lifecycle component.
+// The handler is stopped by cancelling its context within HandlerStopTimeout.
+func HandlerComponent(name string, handler ServiceHandler, dependsOn ...string) *lifecycle.Component {
+	return &lifecycle.Component{
+		Name:        name,
+		DependsOn:   dependsOn,
+		Run:         lifecycle.Hook(handler),
+		StopTimeout: HandlerStopTimeout(),
+	}
 }
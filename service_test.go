@@ -0,0 +1,45 @@
+package microservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/94peter/microservice/health"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+func TestHandlerStopTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		drain    string
+		viper    string
+		graceful string
+		delay    time.Duration
+		want     time.Duration
+	}{
+		{"default drain", "", "", "", 5 * time.Second, 25 * time.Second},
+		{"env drain", "", "", "30s", 5 * time.Second, 45 * time.Second},
+		{"env drain delay", "1s", "", "", time.Second, 21 * time.Second},
+		{"config drain delay", "1s", "20s", "", 20 * time.Second, 40 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HEALTH_DRAIN_DELAY", tt.drain)
+			t.Setenv("GRPC_GRACEFUL_TIMEOUT", tt.graceful)
+			if tt.viper != "" {
+				viper.Set("health.drain_delay", tt.viper)
+			}
+			defer viper.Reset()
+			if got := HandlerComponent("api", nil).StopTimeout; got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+			// the registry drains as long as the stop timeout assumes
+			reg := health.NewRegistry()
+			WithHealth(reg)(&ginServ{Engine: gin.New()})
+			if reg.DrainDelay != tt.delay {
+				t.Errorf("expected drain delay %s, got %s", tt.delay, reg.DrainDelay)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package tlstool
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/94peter/microservice/internal/filewatch"
+)
+
+// CAReloader keeps a CA pool loaded from a PEM file and reloads it when the
+// file changes.
+type CAReloader struct {
+	caFile string
+	pool   atomic.Pointer[x509.CertPool]
+}
+
+func NewCAReloader(caFile string) (*CAReloader, error) {
+	r := &CAReloader{caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CAReloader) Reload() error {
+	b, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return fmt.Errorf("load ca [%s] fail: %w", r.caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("ca [%s] has no valid certificate", r.caFile)
+	}
+	r.pool.Store(pool)
+	return nil
+}
+
+func (r *CAReloader) Pool() *x509.CertPool {
+	return r.pool.Load()
+}
+
+func (r *CAReloader) Watch(ctx context.Context, onError func(error)) error {
+	return filewatch.Watch(ctx, []string{r.caFile}, func() {
+		if err := r.Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+// Config describes the files of a TLS endpoint. For a server, CA enables
+// client certificate verification; for a client, CA overrides the system
+// roots and Cert/Key are presented as client certificate.
+type Config struct {
+	Cert               string `yaml:"cert"`
+	Key                string `yaml:"key"`
+	CA                 string `yaml:"ca"`
+	ServerName         string `yaml:"serverName"`
+	RequireClientCert  bool   `yaml:"requireClientCert"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// Loaded is a tls.Config whose certificates are reloaded by Watch.
+type Loaded struct {
+	*tls.Config
+	cert *CertReloader
+	ca   *CAReloader
+}
+
+// Watch reloads the certificate and CA files on change until ctx is done.
+func (l *Loaded) Watch(ctx context.Context, onError func(error)) error {
+	errCh := make(chan error, 2)
+	n := 0
+	if l.cert != nil {
+		n++
+		go func() { errCh <- l.cert.Watch(ctx, onError) }()
+	}
+	if l.ca != nil {
+		n++
+		go func() { errCh <- l.ca.Watch(ctx, onError) }()
+	}
+	var errs []error
+	for i := 0; i < n; i++ {
+		errs = append(errs, <-errCh)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) load() (*Loaded, error) {
+	l := &Loaded{}
+	if (c.Cert == "") != (c.Key == "") {
+		return nil, errors.New("tls cert and key must be set together")
+	}
+	var err error
+	if c.Cert != "" {
+		if l.cert, err = NewCertReloader(c.Cert, c.Key); err != nil {
+			return nil, err
+		}
+	}
+	if c.CA != "" {
+		if l.ca, err = NewCAReloader(c.CA); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (c *Config) ServerConfig() (*Loaded, error) {
+	if c.Cert == "" {
+		return nil, errors.New("tls cert must not be empty")
+	}
+	l, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	if c.RequireClientCert && l.ca == nil {
+		return nil, errors.New("tls ca must be set to verify client certificates")
+	}
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: l.cert.GetCertificate,
+	}
+	if l.ca != nil {
+		// the CA pool is read per handshake so a rotated CA applies to new connections
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := base.Clone()
+			cfg.GetConfigForClient = nil
+			cfg.ClientCAs = l.ca.Pool()
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			if c.RequireClientCert {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		}
+	}
+	l.Config = base
+	return l, nil
+}
+
+func (c *Config) ClientConfig() (*Loaded, error) {
+	l, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if l.cert != nil {
+		cfg.GetClientCertificate = l.cert.GetClientCertificate
+	}
+	if l.ca != nil && !c.InsecureSkipVerify {
+		// verify against the current pool instead of a RootCAs snapshot
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPeer(cs, l.ca.Pool(), c.ServerName)
+		}
+	}
+	l.Config = cfg
+	return l, nil
+}
+
+func verifyPeer(cs tls.ConnectionState, roots *x509.CertPool, serverName string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: no peer certificate")
+	}
+	if serverName == "" {
+		serverName = cs.ServerName
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,124 @@
+package tlstool_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/94peter/microservice/tlstool"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCert(t *testing.T, dir, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	writePem(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	writePem(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDer)
+	return &testCert{cert: cert, key: key}
+}
+
+func writePem(t *testing.T, file, typ string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func handshake(serverCfg, clientCfg *tls.Config) (serverErr, clientErr error) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	errCh := make(chan error, 1)
+	serverCfg = serverCfg.Clone()
+	// nothing must be written after the client finished, the pipe is closed then
+	serverCfg.SessionTicketsDisabled = true
+	go func() {
+		errCh <- tls.Server(c1, serverCfg).Handshake()
+	}()
+	clientErr = tls.Client(c2, clientCfg).Handshake()
+	c2.Close()
+	return <-errCh, clientErr
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, dir, "ca", nil, true)
+	newCert(t, dir, "server.local", ca, false)
+	newCert(t, dir, "client", ca, false)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	server, err := (&tlstool.Config{
+		Cert:              file("server.local.crt"),
+		Key:               file("server.local.key"),
+		CA:                file("ca.crt"),
+		RequireClientCert: true,
+	}).ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := (&tlstool.Config{
+		Cert:       file("client.crt"),
+		Key:        file("client.key"),
+		CA:         file("ca.crt"),
+		ServerName: "server.local",
+	}).ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverErr, clientErr := handshake(server.Config, client.Config); serverErr != nil || clientErr != nil {
+		t.Fatalf("expected handshake to succeed, got server: %v, client: %v", serverErr, clientErr)
+	}
+
+	anonymous, err := (&tlstool.Config{CA: file("ca.crt"), ServerName: "server.local"}).ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverErr, _ := handshake(server.Config, anonymous.Config); serverErr == nil {
+		t.Error("expected server to reject client without certificate")
+	}
+
+	wrongName, err := (&tlstool.Config{CA: file("ca.crt"), ServerName: "other.local"}).ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, clientErr := handshake(server.Config, wrongName.Config); clientErr == nil {
+		t.Error("expected client to reject server name mismatch")
+	}
+}
@@ -0,0 +1,56 @@
+package tlstool
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/94peter/microservice/internal/filewatch"
+)
+
+// CertReloader keeps a key pair loaded from disk and reloads it when the
+// files change, so certificates can be rotated without a restart.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair [%s, %s] fail: %w", r.certFile, r.keyFile, err)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Watch reloads the key pair on file change until ctx is done. A failed
+// reload keeps the previous certificate and is reported to onError.
+func (r *CertReloader) Watch(ctx context.Context, onError func(error)) error {
+	return filewatch.Watch(ctx, []string{r.certFile, r.keyFile}, func() {
+		if err := r.Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
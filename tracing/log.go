@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/94peter/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewLogger prefixes the messages of l with the trace id of the span in ctx.
+// l is returned as is when ctx has no span.
+func NewLogger(ctx context.Context, l log.Logger) log.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return l
+	}
+	return &traceLogger{
+		Logger: l,
+		prefix: fmt.Sprintf("[trace_id=%s span_id=%s] ", sc.TraceID(), sc.SpanID()),
+	}
+}
+
+type traceLogger struct {
+	log.Logger
+	prefix string
+}
+
+func (l *traceLogger) Info(msg string) {
+	l.Logger.Info(l.prefix + msg)
+}
+
+func (l *traceLogger) Infof(format string, a ...any) {
+	l.Logger.Infof(l.prefix+format, a...)
+}
+
+func (l *traceLogger) Debug(msg string) {
+	l.Logger.Debug(l.prefix + msg)
+}
+
+func (l *traceLogger) Debugf(format string, a ...any) {
+	l.Logger.Debugf(l.prefix+format, a...)
+}
+
+func (l *traceLogger) Warn(msg string) {
+	l.Logger.Warn(l.prefix + msg)
+}
+
+func (l *traceLogger) Warnf(format string, a ...any) {
+	l.Logger.Warnf(l.prefix+format, a...)
+}
+
+func (l *traceLogger) WarnPkg(err error) {
+	l.Logger.WarnPkg(l.wrap(err))
+}
+
+func (l *traceLogger) Error(msg string) {
+	l.Logger.Error(l.prefix + msg)
+}
+
+func (l *traceLogger) Errorf(format string, a ...any) {
+	l.Logger.Errorf(l.prefix+format, a...)
+}
+
+func (l *traceLogger) ErrorPkg(err error) {
+	l.Logger.ErrorPkg(l.wrap(err))
+}
+
+func (l *traceLogger) Fatal(msg string) {
+	l.Logger.Fatal(l.prefix + msg)
+}
+
+func (l *traceLogger) Fatalf(format string, a ...any) {
+	l.Logger.Fatalf(l.prefix+format, a...)
+}
+
+func (l *traceLogger) FatalPkg(err error) {
+	l.Logger.FatalPkg(l.wrap(err))
+}
+
+func (l *traceLogger) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s%w", l.prefix, err)
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/94peter/microservice/cfg"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Config selects the span exporter. OTLPEndpoint defaults to the
+// OTEL_EXPORTER_OTLP_* variables of the OTLP exporter.
+type Config struct {
+	Exporter      string `env:"TRACE_EXPORTER,opt"`
+	OTLPEndpoint  string `env:"TRACE_OTLP_ENDPOINT,opt"`
+	OTLPInsecure  bool   `env:"TRACE_OTLP_INSECURE,opt"`
+	File          string `env:"TRACE_FILE,opt"`
+	SamplePercent int    `env:"TRACE_SAMPLE_PERCENT,opt"`
+}
+
+func GetConfigFromEnv() (*Config, error) {
+	var mycfg Config
+	err := cfg.GetFromEnv(&mycfg)
+	if err != nil {
+		return nil, err
+	}
+	return &mycfg, nil
+}
+
+// Init installs the global tracer provider exporting the spans of service
+// and the W3C trace context propagator. The returned function flushes the
+// pending spans and stops the provider; pass it to lifecycle as Stop.
+// Without exporter only the trace context is propagated.
+func Init(ctx context.Context, service string, c *Config) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	if c == nil || c.Exporter == "" || c.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+	if c.SamplePercent < 0 || c.SamplePercent > 100 {
+		return nil, errors.New("trace sample percent must be between 0 and 100")
+	}
+	exporter, closer, err := newExporter(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	sampler := sdktrace.AlwaysSample()
+	if c.SamplePercent > 0 && c.SamplePercent < 100 {
+		sampler = sdktrace.TraceIDRatioBased(float64(c.SamplePercent) / 100)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, c *Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch c.Exporter {
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if c.OTLPEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(c.OTLPEndpoint))
+		}
+		if c.OTLPInsecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, opts...)
+		return exporter, nil, err
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exporter, nil, err
+	case ExporterFile:
+		if c.File == "" {
+			return nil, nil, errors.New("trace file is empty")
+		}
+		f, err := os.OpenFile(c.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+	}
+	return nil, nil, fmt.Errorf("unknown trace exporter [%s]", c.Exporter)
+}
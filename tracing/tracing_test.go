@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/94peter/log"
+	"go.opentelemetry.io/otel"
+)
+
+type recordLog struct {
+	log.Logger
+	msgs []string
+}
+
+func (l *recordLog) Info(msg string) {
+	l.msgs = append(l.msgs, msg)
+}
+
+func TestInitFileExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "trace.json")
+	shutdown, err := Init(context.Background(), "test", &Config{Exporter: ExporterFile, File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := otel.Tracer("test").Start(context.Background(), "work")
+	l := &recordLog{}
+	NewLogger(ctx, l).Info("hello")
+	NewLogger(context.Background(), l).Info("no span")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	traceID := span.SpanContext().TraceID().String()
+	if len(l.msgs) != 2 || !strings.Contains(l.msgs[0], "trace_id="+traceID) || l.msgs[1] != "no span" {
+		t.Errorf("unexpected log messages %q", l.msgs)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), traceID) {
+		t.Errorf("span not exported: %s", b)
+	}
+}